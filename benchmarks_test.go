@@ -5,6 +5,7 @@ import (
 	"log"
 	"math/rand"
 	"testing"
+	"time"
 )
 
 // TestTokenComparison runs a comparison test and prints results
@@ -268,3 +269,105 @@ func TestTokenComparisonHighColumnCount(t *testing.T) {
 	log.Printf("Flattened:   	%d bytes, %d tokens, %.2f%% tokens, %.2f%% ind tokens", comparisonFlat.JetBytes, comparisonFlat.JetTokens, comparisonFlat.TokenSavings, comparisonFlat.TokenInSavings)
 	log.Printf("Ultimate Savings: %.2f%% bytes, %.2f%% tokens", comparisonFlat.ByteSavings, comparisonFlat.TokenSavings)
 }
+
+type benchItem struct {
+	ProductID   int
+	ProductName string
+	Quantity    int
+	Price       float64
+	Note        string `jet:"note,omitempty"`
+}
+
+type benchOrder struct {
+	OrderID   int
+	OrderDate time.Time `jet:"date,format=date"`
+	Status    string
+	Items     []benchItem
+}
+
+type benchCustomer struct {
+	ID      int
+	Name    string
+	Email   string
+	Active  bool
+	Address struct {
+		City    string
+		Country string
+	}
+	Orders []benchOrder
+}
+
+func benchItems(n int) []benchItem {
+	items := make([]benchItem, n)
+	for i := range items {
+		items[i] = benchItem{ProductID: i, ProductName: fmt.Sprintf("Product %d", i), Quantity: i % 7, Price: float64(i) * 1.25}
+	}
+	return items
+}
+
+func benchCustomers(n int) []benchCustomer {
+	customers := make([]benchCustomer, n)
+	for i := range customers {
+		c := &customers[i]
+		c.ID, c.Name, c.Email, c.Active = i, fmt.Sprintf("Customer %d", i), fmt.Sprintf("c%d@example.com", i), i%2 == 0
+		c.Address.City, c.Address.Country = "Wonderland", "WL"
+		for j := 0; j < 5; j++ {
+			c.Orders = append(c.Orders, benchOrder{
+				OrderID:   i*10 + j,
+				OrderDate: time.Date(2024, 1, j+1, 0, 0, 0, 0, time.UTC),
+				Status:    "shipped",
+				Items:     benchItems(3),
+			})
+		}
+	}
+	return customers
+}
+
+func BenchmarkMarshalTable(b *testing.B) {
+	items := benchItems(1000)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Marshal(items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalTableDeclarationOrder(b *testing.B) {
+	items := benchItems(1000)
+	opts := EncodeOptions{KeyOrder: DeclarationOrder, Keys: []string{"quantity"}}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := MarshalWithOptions(items, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalNested(b *testing.B) {
+	customers := benchCustomers(100)
+	for name, format := range map[string]Format{"normal": FormatNormal, "normalized": FormatNormalized, "flattened": FormatFlattened} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := MarshalWithOptions(customers, EncodeOptions{Format: format}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalTable(b *testing.B) {
+	data, err := Marshal(benchItems(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		var items []benchItem
+		if err := Unmarshal(data, &items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
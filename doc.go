@@ -34,6 +34,14 @@
 //	// Normalized format - pipe-delimited nested values
 //	normalized, err := jet.MarshalNormalized(data)
 //
+//	// Declared field order instead of sorted keys
+//	ordered, err := jet.MarshalWithOptions(data, jet.EncodeOptions{KeyOrder: jet.DeclarationOrder})
+//
+// Unmarshal Jet back into Go values:
+//
+//	var people []Person
+//	err := jet.Unmarshal(result, &people)
+//
 // # Format Modes
 //
 // Normal Format - Best for readability:
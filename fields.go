@@ -0,0 +1,174 @@
+package jet
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// field is a struct field as seen by Marshal and Unmarshal: its Jet key,
+// the index path leading to it through embedded structs and the options
+// of its jet tag.
+type field struct {
+	name   string
+	index  []int
+	typ    reflect.Type
+	opts   tagOptions
+	tagged bool // the name comes from a jet tag
+}
+
+// typeFields returns the fields of struct type t that Marshal writes, in
+// declaration order, following the rules of encoding/json:
+//
+//   - unexported fields are skipped;
+//   - the exported fields of untagged embedded structs, and of struct fields
+//     tagged `jet:",inline"`, are promoted into t, while an embedded struct
+//     with a tag name such as `jet:"base"` is kept as a nested object;
+//   - among fields sharing a name, the least deeply nested one wins, then
+//     the one named by a tag; if that still leaves several, all of them are
+//     dropped.
+func typeFields(t reflect.Type) []field {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields []field
+	visited := map[reflect.Type]bool{}
+	for next := []embedded{{typ: t}}; len(next) > 0; {
+		current := next
+		next = nil
+
+		// A struct embedded twice at the same depth is expanded twice, so
+		// that its fields cancel each other out.
+		var level []embedded
+		for _, e := range current {
+			if !visited[e.typ] {
+				level = append(level, e)
+			}
+		}
+		for _, e := range level {
+			visited[e.typ] = true
+		}
+
+		for _, e := range level {
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					// Exported fields of unexported embedded structs are
+					// still promoted.
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				name, ok := fieldName(sf)
+				if !ok {
+					continue
+				}
+				tagName, opts := parseTag(sf.Tag.Get("jet"))
+				if !sf.IsExported() && tagName != "" && !opts.Contains("inline") {
+					// A tag name would make an unexported embedded struct a
+					// field of its own, whose value cannot be read.
+					continue
+				}
+				index := append(append([]int(nil), e.index...), i)
+				if ft.Kind() == reflect.Struct && (opts.Contains("inline") || (sf.Anonymous && tagName == "")) {
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+				fields = append(fields, field{name: name, index: index, typ: sf.Type, opts: opts, tagged: tagName != ""})
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+
+	dominant := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		group := fields[i:j]
+		if len(group) == 1 || len(group[0].index) < len(group[1].index) || (group[0].tagged && !group[1].tagged) {
+			dominant = append(dominant, group[0])
+		}
+		i = j
+	}
+
+	sort.Slice(dominant, func(i, j int) bool {
+		return indexLess(dominant[i].index, dominant[j].index)
+	})
+	return dominant
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedTypeFields is like typeFields but uses a cache to avoid repeated
+// work. The returned slice must not be modified.
+func cachedTypeFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+// indexLess orders index paths by declaration order.
+func indexLess(a, b []int) bool {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex returns the field of v at index. It reports false when the
+// path goes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc is like fieldByIndex but allocates nil embedded
+// pointers on the way. It reports false when such a pointer cannot be set
+// because its embedded type is unexported.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
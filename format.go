@@ -1,116 +1,221 @@
 package jet
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-type formatMode int
+// nullToken is written for nil pointers, interfaces, maps and slices.
+const nullToken = "~"
 
-const (
-	modeNormal formatMode = iota
-	modeFlattened
-	modeNormalized
-)
+// elisionMarker is written for objects and lists nested deeper than
+// EncodeOptions.MaxDepth.
+const elisionMarker = "..."
 
-type jetWriter struct {
-	sb   *strings.Builder
-	mode formatMode
+// object is an encoded struct or map. Its keys are in declaration order for
+// structs and sorted for maps; the writer reorders them as configured.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+	sorted bool // keys are map keys, sorted numerically where they are numbers
 }
 
-func format(data interface{}) ([]byte, error) {
-	w := &jetWriter{
-		sb:   &strings.Builder{},
-		mode: modeNormal,
-	}
-	err := w.writeValue(data, 0)
-	if err != nil {
-		return nil, err
+func newObject(size int) *object {
+	return &object{keys: make([]string, 0, size), values: make(map[string]interface{}, size)}
+}
+
+// set adds key, or replaces its value while keeping its position.
+func (o *object) set(key string, value interface{}) {
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
 	}
-	return []byte(w.sb.String()), nil
+	o.values[key] = value
+}
+
+func (o *object) has(key string) bool {
+	_, exists := o.values[key]
+	return exists
 }
 
-func formatFlattened(data interface{}) ([]byte, error) {
+// stringWriter is the output of a jetWriter: a bytes.Buffer for Marshal,
+// or a bufio.Writer for an Encoder.
+type stringWriter interface {
+	Write(p []byte) (int, error)
+	WriteString(s string) (int, error)
+}
+
+type jetWriter struct {
+	sb   stringWriter
+	opts EncodeOptions
+}
+
+func format(data interface{}, opts EncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
 	w := &jetWriter{
-		sb:   &strings.Builder{},
-		mode: modeFlattened,
+		sb:   &buf,
+		opts: opts,
 	}
 	err := w.writeValue(data, 0)
 	if err != nil {
 		return nil, err
 	}
-	return []byte(w.sb.String()), nil
+	return buf.Bytes(), nil
 }
 
-func formatNormalized(data interface{}) ([]byte, error) {
-	w := &jetWriter{
-		sb:   &strings.Builder{},
-		mode: modeNormalized,
+// orderKeys returns the keys of obj in the order configured by the KeyOrder
+// and Keys options: the listed keys first, then the others sorted or as
+// declared. Map keys are already in their sorted order.
+// The result must not be modified.
+func (w *jetWriter) orderKeys(obj *object) []string {
+	inOrder := w.opts.KeyOrder != SortedOrder || obj.sorted || sort.StringsAreSorted(obj.keys)
+	if inOrder && len(w.opts.Keys) == 0 {
+		return obj.keys
 	}
-	err := w.writeValue(data, 0)
-	if err != nil {
-		return nil, err
+	ordered := append([]string(nil), obj.keys...)
+	if !inOrder {
+		sort.Strings(ordered)
+	}
+	if len(w.opts.Keys) == 0 {
+		return ordered
 	}
-	return []byte(w.sb.String()), nil
+
+	rank := make(map[string]int, len(w.opts.Keys))
+	for i, key := range w.opts.Keys {
+		if _, exists := rank[key]; !exists {
+			rank[key] = i
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, iListed := rank[ordered[i]]
+		rj, jListed := rank[ordered[j]]
+		if iListed && jListed {
+			return ri < rj
+		}
+		return iListed && !jListed
+	})
+	return ordered
 }
 
 func (w *jetWriter) writeValue(data interface{}, indentLevel int) error {
 	indentStr := strings.Repeat(" ", indentLevel)
 
 	switch v := data.(type) {
-	// Handling maps
-	case map[string]interface{}:
-		keys := make([]string, 0, len(v))
-		for key := range v {
-			keys = append(keys, key)
-		}
-
-		sort.Strings(keys)
-		for _, key := range keys {
-			value := v[key]
-			if subSlice, ok := value.([]interface{}); ok && isTabular(subSlice) {
-				w.writeTabularArray(indentStr, key, subSlice, indentLevel)
-			} else if subMap, ok := value.(map[string]interface{}); ok {
-				// Nested object
-				w.sb.WriteString(fmt.Sprintf("%s%s:\n", indentStr, key))
-				w.writeValue(subMap, indentLevel+1)
+	// Handling objects
+	case *object:
+		for _, key := range w.orderKeys(v) {
+			value := v.values[key]
+			if _, ok := value.(omitted); ok {
+				continue
+			}
+			if table := w.asTable(value); table != nil {
+				w.writeTabularArray(indentStr, formatKey(key), table, indentLevel)
+			} else if subSlice, ok := value.([]interface{}); ok && isMatrix(subSlice) {
+				w.writeMatrix(indentStr, formatKey(key), subSlice, indentLevel)
+			} else if t, ok := value.(*structTable); ok {
+				w.writeStructTable(indentStr, formatKey(key), t, indentLevel)
+			} else if isBlock(value) {
+				// Nested object or list
+				w.sb.WriteString(fmt.Sprintf("%s%s:\n", indentStr, formatKey(key)))
+				w.writeValue(value, indentLevel+1)
 			} else {
 				// Simple key-value pair
-				w.sb.WriteString(fmt.Sprintf("%s%s: %v\n", indentStr, key, value))
+				w.sb.WriteString(fmt.Sprintf("%s%s: %s\n", indentStr, formatKey(key), formatScalar(value)))
 			}
 		}
 	case []interface{}:
 		// Handle non-tabular arrays
-		if isTabular(v) {
+		if len(v) == 0 {
+			// Written as for a key's value, so that it reads back as a list
+			w.sb.WriteString(indentStr + formatList(v) + "\n")
+		} else if table := w.asTable(v); table != nil {
 			// This shouldn't happen in normal flow, but handle it
-			w.writeTabularArray(indentStr, "", v, indentLevel)
+			w.writeTabularArray(indentStr, "", table, indentLevel)
+		} else if isMatrix(v) {
+			w.writeMatrix(indentStr, "", v, indentLevel)
 		} else {
-			// Simple array - not implemented in spec, but handle gracefully
 			for _, item := range v {
-				w.sb.WriteString(fmt.Sprintf("%s- %v\n", indentStr, item))
+				w.writeListItem(item, indentLevel)
 			}
 		}
+	case *structTable:
+		w.writeStructTable(indentStr, "", v, indentLevel)
 	default:
 		// Scalar value
-		w.sb.WriteString(fmt.Sprintf("%s%v\n", indentStr, v))
+		w.sb.WriteString(fmt.Sprintf("%s%s\n", indentStr, formatScalar(v)))
 	}
 
 	return nil
 }
 
-func (w *jetWriter) writeTabularArray(indentStr, key string, data []interface{}, indentLevel int) {
-	firstRow := data[0].(map[string]interface{})
-	schema := make([]string, 0, len(firstRow))
-	for k := range firstRow {
-		schema = append(schema, k)
+// writeListItem writes item as a "- " line. An object, table or list item
+// is written as a block indented past the dash, its first line following
+// the dash; an item writing no lines, such as an empty object, is a lone
+// dash.
+func (w *jetWriter) writeListItem(item interface{}, indentLevel int) {
+	indentStr := strings.Repeat(" ", indentLevel)
+	if !isBlock(item) {
+		w.sb.WriteString(fmt.Sprintf("%s- %s\n", indentStr, formatScalar(item)))
+		return
+	}
+
+	var sb strings.Builder
+	sub := &jetWriter{sb: &sb, opts: w.opts}
+	sub.writeValue(item, indentLevel+2)
+	block := sb.String()
+	if block == "" {
+		w.sb.WriteString(indentStr + "-\n")
+		return
+	}
+	w.sb.WriteString(indentStr + "- ")
+	w.sb.WriteString(block[indentLevel+2:])
+}
+
+// writeMatrix writes a list of scalar lists as a "key[RxC]:" header
+// followed by one pipe-delimited row per inner list. Ragged matrices,
+// whose inner lists differ in length, are declared as "key[Rx*]:".
+func (w *jetWriter) writeMatrix(indentStr, key string, rows []interface{}, indentLevel int) {
+	cols := strconv.Itoa(len(rows[0].([]interface{})))
+	for _, row := range rows {
+		if len(row.([]interface{})) != len(rows[0].([]interface{})) {
+			cols = "*"
+			break
+		}
+	}
+	w.sb.WriteString(fmt.Sprintf("%s%s[%dx%s]:\n", indentStr, key, len(rows), cols))
+
+	rowDataIndent := strings.Repeat(" ", indentLevel+1)
+	for _, row := range rows {
+		items := row.([]interface{})
+		values := make([]string, len(items))
+		for i, item := range items {
+			values[i] = formatScalar(item)
+		}
+		w.sb.WriteString(rowDataIndent)
+		w.sb.WriteString(strings.Join(values, "|"))
+		w.sb.WriteString("\n")
+	}
+}
+
+func (w *jetWriter) writeTabularArray(indentStr, key string, table *tableShape, indentLevel int) {
+	data := table.rows
+	if !table.sameKeys {
+		data = padRows(data, table.union)
+	}
+	schema := make([]string, 0, len(table.union.keys))
+	for _, k := range w.orderKeys(table.union) {
+		if !omittedInAllRows(k, data) {
+			schema = append(schema, k)
+		}
 	}
-	sort.Strings(schema)
 
-	switch w.mode {
-	case modeFlattened:
+	switch w.opts.Format {
+	case FormatFlattened:
 		w.writeTabularArrayFlattened(indentStr, key, data, schema, indentLevel)
-	case modeNormalized:
+	case FormatNormalized:
 		w.writeTabularArrayNormalized(indentStr, key, data, schema, indentLevel)
 	default:
 		w.writeTabularArrayNormal(indentStr, key, data, schema, indentLevel)
@@ -120,24 +225,22 @@ func (w *jetWriter) writeTabularArray(indentStr, key string, data []interface{},
 // writeTabularArrayNormal writes the normal format with nested blocks using > sigil
 func (w *jetWriter) writeTabularArrayNormal(indentStr, key string, data []interface{}, schema []string, indentLevel int) {
 	// Write header
-	w.sb.WriteString(fmt.Sprintf("%s%s{%s}:\n", indentStr, key, strings.Join(schema, "|")))
+	w.sb.WriteString(fmt.Sprintf("%s%s{%s}:\n", indentStr, key, strings.Join(formatKeys(schema), "|")))
 
 	// Write rows
 	rowDataIndent := strings.Repeat(" ", indentLevel+1)
 	for _, row := range data {
-		rowMap := row.(map[string]interface{})
+		rowObj := row.(*object)
 		w.sb.WriteString(rowDataIndent)
 
 		// Handle nesting
 		values := []string{}
 		for _, col := range schema {
-			val := rowMap[col]
-			if _, ok := val.(map[string]interface{}); ok {
-				// Ignore
-			} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
+			val := rowObj.values[col]
+			if isBlock(val) {
 				// Ignore
 			} else {
-				values = append(values, fmt.Sprintf("%v", val))
+				values = append(values, formatScalar(val))
 			}
 		}
 		w.sb.WriteString(strings.Join(values, "|"))
@@ -145,13 +248,19 @@ func (w *jetWriter) writeTabularArrayNormal(indentStr, key string, data []interf
 
 		// Handle nesting
 		for _, col := range schema {
-			val := rowMap[col]
-			if subMap, ok := val.(map[string]interface{}); ok {
-				w.sb.WriteString(fmt.Sprintf("%s  > %s:\n", rowDataIndent, col))
-				w.writeValue(subMap, indentLevel+2)
-			} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
+			val := rowObj.values[col]
+			if table := w.asTable(val); table != nil {
+				w.sb.WriteString(fmt.Sprintf("%s  > ", rowDataIndent))
+				w.writeTabularArray("", formatKey(col), table, indentLevel+2)
+			} else if subSlice, ok := val.([]interface{}); ok && isMatrix(subSlice) {
 				w.sb.WriteString(fmt.Sprintf("%s  > ", rowDataIndent))
-				w.writeTabularArray("", col, subSlice, indentLevel+2)
+				w.writeMatrix("", formatKey(col), subSlice, indentLevel+2)
+			} else if t, ok := val.(*structTable); ok {
+				w.sb.WriteString(fmt.Sprintf("%s  > ", rowDataIndent))
+				w.writeStructTable("", formatKey(col), t, indentLevel+2)
+			} else if isBlock(val) {
+				w.sb.WriteString(fmt.Sprintf("%s  > %s:\n", rowDataIndent, formatKey(col)))
+				w.writeValue(val, indentLevel+2)
 			}
 		}
 	}
@@ -159,9 +268,9 @@ func (w *jetWriter) writeTabularArrayNormal(indentStr, key string, data []interf
 
 // writeTabularArrayFlattened writes flattened format with nested scalar objects inline
 func (w *jetWriter) writeTabularArrayFlattened(indentStr, key string, data []interface{}, schema []string, indentLevel int) {
-	firstRow := data[0].(map[string]interface{})
+	groups := w.groupColumns(schema, data)
 	// Build flattened schema and collect values
-	flatSchema := w.buildFlattenedSchema(schema, firstRow)
+	flatSchema := w.buildFlattenedSchema(schema, groups)
 
 	// Write header
 	w.sb.WriteString(fmt.Sprintf("%s%s{%s}:\n", indentStr, key, flatSchema))
@@ -169,10 +278,10 @@ func (w *jetWriter) writeTabularArrayFlattened(indentStr, key string, data []int
 	// Write rows
 	rowDataIndent := strings.Repeat(" ", indentLevel+1)
 	for _, row := range data {
-		rowMap := row.(map[string]interface{})
+		rowObj := row.(*object)
 		w.sb.WriteString(rowDataIndent)
 
-		values := w.extractFlattenedValues(schema, rowMap)
+		values := w.extractFlattenedValues(schema, rowObj, groups)
 		w.sb.WriteString(strings.Join(values, "|"))
 		w.sb.WriteString("\n")
 	}
@@ -180,9 +289,9 @@ func (w *jetWriter) writeTabularArrayFlattened(indentStr, key string, data []int
 
 // writeTabularArrayNormalized writes normalized format with nested objects as pipe-delimited blocks
 func (w *jetWriter) writeTabularArrayNormalized(indentStr, key string, data []interface{}, schema []string, indentLevel int) {
-	firstRow := data[0].(map[string]interface{})
+	groups := w.groupColumns(schema, data)
 	// Build normalized schema showing nested structure
-	normalizedSchema := w.buildNormalizedSchema(schema, firstRow)
+	normalizedSchema := w.buildNormalizedSchema(schema, groups)
 
 	// Write header
 	w.sb.WriteString(fmt.Sprintf("%s%s{%s}:\n", indentStr, key, normalizedSchema))
@@ -190,19 +299,17 @@ func (w *jetWriter) writeTabularArrayNormalized(indentStr, key string, data []in
 	// Write rows
 	rowDataIndent := strings.Repeat(" ", indentLevel+1)
 	for _, row := range data {
-		rowMap := row.(map[string]interface{})
+		rowObj := row.(*object)
 		w.sb.WriteString(rowDataIndent)
 
 		// Write scalar values
 		values := []string{}
 		for _, col := range schema {
-			val := rowMap[col]
-			if _, ok := val.(map[string]interface{}); ok {
-				// Skip - will be handled in nested block
-			} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
+			val := rowObj.values[col]
+			if isBlock(val) {
 				// Skip - will be handled in nested block
 			} else {
-				values = append(values, fmt.Sprintf("%v", val))
+				values = append(values, formatScalar(val))
 			}
 		}
 		w.sb.WriteString(strings.Join(values, "|"))
@@ -210,63 +317,43 @@ func (w *jetWriter) writeTabularArrayNormalized(indentStr, key string, data []in
 
 		// Handle nesting with normalized format
 		for _, col := range schema {
-			val := rowMap[col]
-			if subMap, ok := val.(map[string]interface{}); ok {
-				if canFlattenObject(subMap) {
-					// Write normalized nested object as pipe-delimited values
-					w.sb.WriteString(fmt.Sprintf("%s  > %s:\n", rowDataIndent, col))
+			val := rowObj.values[col]
+			if subKeys, ok := groups[col]; ok {
+				// Write normalized nested object as pipe-delimited values,
+				// keyed by the header. Null objects are in the row itself.
+				if subObj, ok := val.(*object); ok {
+					w.sb.WriteString(fmt.Sprintf("%s  > %s:\n", rowDataIndent, formatKey(col)))
 					w.sb.WriteString(rowDataIndent + "  ")
-
-					subKeys := make([]string, 0, len(subMap))
-					for k := range subMap {
-						subKeys = append(subKeys, k)
-					}
-					sort.Strings(subKeys)
-
-					subValues := []string{}
-					for _, subKey := range subKeys {
-						subValues = append(subValues, fmt.Sprintf("%v", subMap[subKey]))
-					}
-					w.sb.WriteString(strings.Join(subValues, "|"))
+					w.sb.WriteString(strings.Join(groupCells(subObj, subKeys), "|"))
 					w.sb.WriteString("\n")
-				} else {
-					// Cannot normalize - has nested structures, use normal format
-					w.sb.WriteString(fmt.Sprintf("%s  > %s:\n", rowDataIndent, col))
-					w.writeValue(subMap, indentLevel+2)
 				}
-			} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
+			} else if table := w.asTable(val); table != nil {
+				w.sb.WriteString(fmt.Sprintf("%s  > ", rowDataIndent))
+				w.writeTabularArray("", formatKey(col), table, indentLevel+2)
+			} else if subSlice, ok := val.([]interface{}); ok && isMatrix(subSlice) {
+				w.sb.WriteString(fmt.Sprintf("%s  > ", rowDataIndent))
+				w.writeMatrix("", formatKey(col), subSlice, indentLevel+2)
+			} else if t, ok := val.(*structTable); ok {
 				w.sb.WriteString(fmt.Sprintf("%s  > ", rowDataIndent))
-				w.writeTabularArray("", col, subSlice, indentLevel+2)
+				w.writeStructTable("", formatKey(col), t, indentLevel+2)
+			} else if isBlock(val) {
+				w.sb.WriteString(fmt.Sprintf("%s  > %s:\n", rowDataIndent, formatKey(col)))
+				w.writeValue(val, indentLevel+2)
 			}
 		}
 	}
 }
 
 // buildFlattenedSchema creates a flattened schema string with nested objects expanded
-func (w *jetWriter) buildFlattenedSchema(schema []string, sampleRow map[string]interface{}) string {
+func (w *jetWriter) buildFlattenedSchema(schema []string, groups map[string][]string) string {
 	var parts []string
 
 	for _, col := range schema {
-		val := sampleRow[col]
-		if subMap, ok := val.(map[string]interface{}); ok {
-			// Check if this object has only simple scalar values (can be flattened)
-			if canFlattenObject(subMap) {
-				// Get subkeys
-				subKeys := make([]string, 0, len(subMap))
-				for k := range subMap {
-					subKeys = append(subKeys, k)
-				}
-				sort.Strings(subKeys)
-				parts = append(parts, fmt.Sprintf("%s{%s}", col, strings.Join(subKeys, ",")))
-			} else {
-				// Cannot flatten - has nested structures, keep as is
-				parts = append(parts, col)
-			}
-		} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
-			// Nested tabular array - cannot flatten inline, keep as is
-			parts = append(parts, col)
+		if subKeys, ok := groups[col]; ok {
+			parts = append(parts, fmt.Sprintf("%s{%s}", formatKey(col), strings.Join(formatKeys(subKeys), ",")))
 		} else {
-			parts = append(parts, col)
+			// Scalars, and nested values that cannot be flattened
+			parts = append(parts, formatKey(col))
 		}
 	}
 
@@ -274,115 +361,402 @@ func (w *jetWriter) buildFlattenedSchema(schema []string, sampleRow map[string]i
 }
 
 // buildNormalizedSchema creates a normalized schema string with nested objects shown with pipe-delimited structure
-func (w *jetWriter) buildNormalizedSchema(schema []string, sampleRow map[string]interface{}) string {
+func (w *jetWriter) buildNormalizedSchema(schema []string, groups map[string][]string) string {
 	var parts []string
 
 	for _, col := range schema {
-		val := sampleRow[col]
-		if subMap, ok := val.(map[string]interface{}); ok {
-			// Check if this object has only simple scalar values (can be normalized)
-			if canFlattenObject(subMap) {
-				// Get subkeys
-				subKeys := make([]string, 0, len(subMap))
-				for k := range subMap {
-					subKeys = append(subKeys, k)
-				}
-				sort.Strings(subKeys)
-				// Use pipe delimiter to indicate values will be pipe-separated in the nested block
-				parts = append(parts, fmt.Sprintf("%s{%s}", col, strings.Join(subKeys, "|")))
-			} else {
-				// Cannot normalize - has nested structures, keep as is
-				parts = append(parts, col)
-			}
-		} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
-			// Nested tabular array - cannot normalize inline, keep as is
-			parts = append(parts, col)
+		if subKeys, ok := groups[col]; ok {
+			// Use pipe delimiter to indicate values will be pipe-separated in the nested block
+			parts = append(parts, fmt.Sprintf("%s{%s}", formatKey(col), strings.Join(formatKeys(subKeys), "|")))
 		} else {
-			parts = append(parts, col)
+			// Scalars, and nested values that cannot be normalized
+			parts = append(parts, formatKey(col))
 		}
 	}
 
 	return strings.Join(parts, "|")
 }
 
+// omittedInAllRows reports whether col holds an omitted omitempty field in
+// every row, in which case the column is left out of the table.
+func omittedInAllRows(col string, data []interface{}) bool {
+	for _, row := range data {
+		if _, ok := row.(*object).values[col].(omitted); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// groupColumns returns the sub-keys of the columns whose nested objects are
+// written as a group of cells by the Flattened and Normalized formats: the
+// union of the keys of the objects in the column, in key order. A column
+// qualifies when every row holds an object of scalars, a null or an omitted
+// value, so that no row has to fall back to another layout than the header
+// announces; the other columns are written as Normal blocks.
+func (w *jetWriter) groupColumns(schema []string, data []interface{}) map[string][]string {
+	groups := make(map[string][]string)
+	for _, col := range schema {
+		var objects []interface{}
+		grouped := true
+		for _, row := range data {
+			switch val := row.(*object).values[col].(type) {
+			case nil, omitted:
+			case *object:
+				grouped = grouped && canFlattenObject(val)
+				objects = append(objects, val)
+			default:
+				grouped = false
+			}
+		}
+		if !grouped || len(objects) == 0 {
+			continue
+		}
+		if subKeys := w.orderKeys(unionKeys(objects)); len(subKeys) > 0 {
+			groups[col] = subKeys
+		}
+	}
+	return groups
+}
+
+// groupCells returns the cells of obj for the sub-keys of its column. A key
+// the object lacks is left empty and read back as absent.
+func groupCells(obj *object, subKeys []string) []string {
+	cells := make([]string, len(subKeys))
+	for i, key := range subKeys {
+		if val, ok := obj.values[key]; ok {
+			cells[i] = formatScalar(val)
+		}
+	}
+	return cells
+}
+
 // extractFlattenedValues extracts values in flattened order
-func (w *jetWriter) extractFlattenedValues(schema []string, rowMap map[string]interface{}) []string {
+func (w *jetWriter) extractFlattenedValues(schema []string, rowObj *object, groups map[string][]string) []string {
 	var values []string
 
 	for _, col := range schema {
-		val := rowMap[col]
-		if subMap, ok := val.(map[string]interface{}); ok {
-			if canFlattenObject(subMap) {
-				// Extract nested values in sorted order
-				subKeys := make([]string, 0, len(subMap))
-				for k := range subMap {
-					subKeys = append(subKeys, k)
-				}
-				sort.Strings(subKeys)
-				for _, subKey := range subKeys {
-					values = append(values, fmt.Sprintf("%v", subMap[subKey]))
-				}
+		val := rowObj.values[col]
+		if subKeys, ok := groups[col]; ok {
+			if subObj, ok := val.(*object); ok {
+				// Extract nested values in the header's key order
+				values = append(values, groupCells(subObj, subKeys)...)
 			} else {
-				// Cannot flatten - output placeholder or skip
-				values = append(values, "[nested]")
+				// A null or omitted object still fills every cell of its inline group
+				for range subKeys {
+					values = append(values, formatScalar(val))
+				}
 			}
-		} else if subSlice, ok := val.([]interface{}); ok && isTabular(subSlice) {
+		} else if _, ok := val.(*object); ok {
+			// Cannot flatten - output placeholder
+			values = append(values, "[nested]")
+		} else if w.asTable(val) != nil {
 			// Nested tabular array - output placeholder
 			values = append(values, "[table]")
+		} else if _, ok := val.(*structTable); ok {
+			values = append(values, "[table]")
+		} else if isBlock(val) {
+			// List of objects or lists - output placeholder
+			values = append(values, "[nested]")
 		} else {
-			values = append(values, fmt.Sprintf("%v", val))
+			values = append(values, formatScalar(val))
 		}
 	}
 
 	return values
 }
 
-// canFlattenObject checks if an object contains only scalar values (no nested objects/arrays)
-func canFlattenObject(obj map[string]interface{}) bool {
-	for _, v := range obj {
-		switch v.(type) {
-		case map[string]interface{}, []interface{}:
+// canFlattenObject checks if an object contains only scalar values (no nested
+// objects or arrays other than inline lists of scalars)
+func canFlattenObject(obj *object) bool {
+	for _, v := range obj.values {
+		switch v := v.(type) {
+		case *object, *structTable:
 			return false
+		case []interface{}:
+			if !isScalarList(v) {
+				return false
+			}
 		}
 	}
 	return true
 }
 
-func isTabular(slice []interface{}) bool {
-	if len(slice) == 0 {
-		return false
+// tableShape is a list of objects written as a table, with the keys of its
+// header worked out once.
+type tableShape struct {
+	rows     []interface{}
+	union    *object // keys of all rows, before ordering
+	sameKeys bool    // every row has all the keys of union
+}
+
+// asTable returns the shape of v when it is written as a table: a list of
+// objects with the same keys or, when MinKeyOverlap is set, with key sets
+// that overlap enough to share a union header. It returns nil otherwise.
+func (w *jetWriter) asTable(v interface{}) *tableShape {
+	rows, ok := v.([]interface{})
+	if !ok || len(rows) == 0 {
+		return nil
+	}
+	for _, item := range rows {
+		if _, ok := item.(*object); !ok {
+			return nil
+		}
 	}
+	if sameKeys(rows) {
+		return &tableShape{rows: rows, union: rows[0].(*object), sameKeys: true}
+	}
+	if w.opts.MinKeyOverlap <= 0 {
+		return nil
+	}
+	union := unionKeys(rows)
+	if keyOverlap(rows, union) < w.opts.MinKeyOverlap {
+		return nil
+	}
+	return &tableShape{rows: rows, union: union}
+}
 
-	firstMap, ok := slice[0].(map[string]interface{})
-	if !ok {
-		return false
+// sameKeys reports whether all the objects of rows have the same keys.
+func sameKeys(rows []interface{}) bool {
+	first := rows[0].(*object)
+	for _, row := range rows[1:] {
+		obj := row.(*object)
+		if len(obj.keys) != len(first.keys) {
+			return false
+		}
+		for _, k := range obj.keys {
+			if !first.has(k) {
+				return false
+			}
+		}
 	}
+	return true
+}
 
-	// Get keys from first map
-	firstKeys := make(map[string]bool)
-	for k := range firstMap {
-		firstKeys[k] = true
+// keyOverlap returns the share of the cells of a table over rows with the
+// union header that would hold a key of their row, from 1 when all rows
+// have the same keys down towards 0 as they diverge.
+func keyOverlap(rows []interface{}, union *object) float64 {
+	filled := 0
+	for _, row := range rows {
+		filled += len(row.(*object).keys)
 	}
+	return float64(filled) / float64(len(rows)*len(union.keys))
+}
 
-	// Verify all items are maps with the same keys
-	for i := 1; i < len(slice); i++ {
-		currentMap, ok := slice[i].(map[string]interface{})
-		if !ok {
-			return false
+// unionKeys returns an object holding the keys of all rows. A key missing
+// from the rows before it is placed after the key that precedes it in its
+// own row, so declaration order carries over to the union; map keys are
+// sorted again.
+func unionKeys(rows []interface{}) *object {
+	first := rows[0].(*object)
+	union := &object{keys: append([]string(nil), first.keys...), values: make(map[string]interface{}, len(first.keys)), sorted: first.sorted}
+	for _, k := range first.keys {
+		union.values[k] = nil
+	}
+	for _, row := range rows[1:] {
+		at := 0
+		for _, k := range row.(*object).keys {
+			if union.has(k) {
+				at = indexOf(union.keys, k) + 1
+				continue
+			}
+			union.keys = append(union.keys[:at], append([]string{k}, union.keys[at:]...)...)
+			union.values[k] = nil
+			at++
 		}
+	}
+	if union.sorted {
+		sort.SliceStable(union.keys, func(i, j int) bool {
+			return lessMapKey(union.keys[i], union.keys[j])
+		})
+	}
+	return union
+}
 
-		// Check same number of keys
-		if len(currentMap) != len(firstKeys) {
-			return false
+// lessMapKey orders written map keys as mapEntries does: numerically when
+// both are integers, as text otherwise.
+func lessMapKey(a, b string) bool {
+	if x, err := strconv.ParseInt(a, 10, 64); err == nil {
+		if y, err := strconv.ParseInt(b, 10, 64); err == nil {
+			return x < y
 		}
+	}
+	if x, err := strconv.ParseUint(a, 10, 64); err == nil {
+		if y, err := strconv.ParseUint(b, 10, 64); err == nil {
+			return x < y
+		}
+	}
+	return a < b
+}
 
-		// Check all keys match
-		for k := range currentMap {
-			if !firstKeys[k] {
-				return false
+func indexOf(keys []string, key string) int {
+	for i, k := range keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// padRows returns copies of rows holding every key of union, the missing
+// ones as omitted values so that they are written as empty cells.
+func padRows(rows []interface{}, union *object) []interface{} {
+	padded := make([]interface{}, len(rows))
+	for i, row := range rows {
+		obj := row.(*object)
+		full := &object{keys: union.keys, values: make(map[string]interface{}, len(union.keys)), sorted: union.sorted}
+		for _, k := range union.keys {
+			if v, ok := obj.values[k]; ok {
+				full.values[k] = v
+			} else {
+				full.values[k] = omitted{}
 			}
 		}
+		padded[i] = full
 	}
+	return padded
+}
 
+// formatScalar renders a value written into a key/value line, a list item
+// or a table cell. Strings are quoted when they could be mistaken for Jet
+// syntax or for a value of another type; other values only when their
+// printed form would break the line.
+func formatScalar(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return nullToken
+	case omitted:
+		return ""
+	case elided:
+		return elisionMarker
+	case []interface{}:
+		if isScalarList(v.([]interface{})) {
+			return formatList(v.([]interface{}))
+		}
+	}
+	if s, ok := v.(string); ok {
+		if needsQuoting(s) || isAmbiguous(s) {
+			return strconv.Quote(s)
+		}
+		return s
+	}
+
+	s := fmt.Sprintf("%v", v)
+	if needsQuoting(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// formatList renders a list of scalars in the inline form "[a,b,c]". Items
+// are quoted like other scalars, and also when they contain ',', '[' or
+// ']'. A lone "nested" or "table" item is quoted so the list cannot be
+// mistaken for a MarshalFlattened placeholder.
+func formatList(items []interface{}) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		text := formatScalar(item)
+		if s, ok := item.(string); ok && text == s {
+			if strings.ContainsAny(s, ",[]") || (len(items) == 1 && (s == "nested" || s == "table")) {
+				text = strconv.Quote(s)
+			}
+		}
+		parts[i] = text
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// isBlock reports whether v is written as a block of lines below its key
+// rather than on the key's line or in a cell: an object, or a list that
+// holds objects or lists.
+func isBlock(v interface{}) bool {
+	switch v := v.(type) {
+	case *object, *structTable:
+		return true
+	case []interface{}:
+		return !isScalarList(v)
+	}
+	return false
+}
+
+// isMatrix reports whether list is a list of scalar lists, at least one of
+// which is not empty, so that it can be written as a matrix.
+func isMatrix(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	cells := 0
+	for _, item := range list {
+		row, ok := item.([]interface{})
+		if !ok || !isScalarList(row) {
+			return false
+		}
+		cells += len(row)
+	}
+	return cells > 0
+}
+
+// isScalarList reports whether list holds no objects or lists, so that it
+// can be written inline.
+func isScalarList(list []interface{}) bool {
+	for _, item := range list {
+		switch item.(type) {
+		case *object, *structTable, []interface{}:
+			return false
+		}
+	}
 	return true
 }
+
+// formatKey renders a map key or column name, quoting it when it contains
+// characters that delimit keys and headers.
+func formatKey(key string) string {
+	if needsQuoting(key) || strings.ContainsAny(key, "{}[|,:") {
+		return strconv.Quote(key)
+	}
+	return key
+}
+
+func formatKeys(keys []string) []string {
+	formatted := make([]string, len(keys))
+	for i, key := range keys {
+		formatted[i] = formatKey(key)
+	}
+	return formatted
+}
+
+// needsQuoting reports whether s, written bare, would be read back as
+// different text or would corrupt the surrounding line. Quoted text uses Go
+// string syntax: backslash escapes for quotes, backslashes and control
+// characters.
+func needsQuoting(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return true
+	}
+	switch s[0] {
+	case ' ', '\t', '"', '>':
+		return true
+	}
+	switch s[len(s)-1] {
+	case ' ', '\t', ':':
+		return true
+	}
+	if s == "-" || strings.HasPrefix(s, "- ") || strings.Contains(s, ": ") {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '|' || s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// isAmbiguous reports whether the string s, written bare, would be read
+// back as something other than a string: a bool or number when decoded into
+// an interface{}, the null token or elision marker, or an inline list or
+// placeholder when it starts with '['.
+func isAmbiguous(s string) bool {
+	return s == "true" || s == "false" || s == nullToken || s == elisionMarker || isNumber(s) || strings.HasPrefix(s, "[")
+}
@@ -0,0 +1,241 @@
+package jet
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+)
+
+// An IncrementalParser reads a Jet document as it arrives in chunks, for
+// example from a model streaming its output, and returns the rows of its
+// tables as soon as they are complete:
+//
+//	p := jet.NewIncrementalParser()
+//	for chunk := range chunks {
+//		rows, err := p.Feed(chunk)
+//		...
+//		render(rows, p.Pending())
+//	}
+//	rows, err := p.Close()
+//
+// A row whose cells cover every column is complete at its newline. A row
+// leaving out the columns written in nested "> field:" blocks is complete
+// once a line that is not part of these blocks arrives, or at Close.
+type IncrementalParser struct {
+	buf    []byte // text after the last newline
+	num    int    // number of lines read
+	offset int64  // byte offset of the next line
+
+	keys  []openKey  // "key:" blocks enclosing the next line
+	table *openTable // table receiving rows, if any
+	row   []line     // lines of a row waiting for its nested blocks
+}
+
+// Row is a table row returned by an IncrementalParser.
+type Row struct {
+	Table  string                 // dotted path of the table's key; a table without a key, such as a list item, has the path of its list
+	Index  int                    // position of the row within its table
+	Values map[string]interface{} // the row as decoded into an interface{}
+
+	node map[string]interface{}
+}
+
+// Decode stores the row in the value pointed to by v, typically a struct,
+// as Unmarshal would.
+func (r Row) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	return decodeNode(r.node, rv.Elem())
+}
+
+type openKey struct {
+	indent int
+	key    string
+}
+
+type openTable struct {
+	name      string
+	header    line
+	columns   []column
+	width     int // number of cells in a row without nested blocks
+	rowIndent int
+	rows      int
+}
+
+// NewIncrementalParser returns a parser ready for the first chunk of a
+// document.
+func NewIncrementalParser() *IncrementalParser {
+	return &IncrementalParser{}
+}
+
+// Feed adds the next chunk of the document and returns the rows it
+// completed. A truncated last line is kept until its newline arrives, and
+// is reported by Pending meanwhile. A row that cannot be parsed is skipped
+// and the first such problem is returned as a *SyntaxError along with the
+// other rows.
+func (ip *IncrementalParser) Feed(chunk []byte) ([]Row, error) {
+	ip.buf = append(ip.buf, chunk...)
+
+	var rows []Row
+	var firstErr error
+	start := 0
+	for {
+		i := bytes.IndexByte(ip.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		raw := string(ip.buf[start : start+i])
+		start += i + 1
+		var err error
+		if rows, err = ip.addLine(rows, raw, int64(i+1)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	ip.buf = append(ip.buf[:0], ip.buf[start:]...)
+	return rows, firstErr
+}
+
+// Pending returns the text of the row still being received: its lines
+// waiting for the nested blocks that may follow, and the last line while
+// its newline has not arrived. It is empty when every row received so far
+// has been returned.
+func (ip *IncrementalParser) Pending() string {
+	var sb strings.Builder
+	for _, l := range ip.row {
+		sb.WriteString(strings.Repeat(" ", l.indent) + l.text + "\n")
+	}
+	sb.Write(ip.buf)
+	return sb.String()
+}
+
+// Close ends the document, completing the row left pending by a missing
+// final newline or by the end of its nested blocks. A row cut short by the
+// end of the stream is reported as a *SyntaxError.
+func (ip *IncrementalParser) Close() ([]Row, error) {
+	var rows []Row
+	if len(ip.buf) > 0 {
+		raw := string(ip.buf)
+		ip.buf = ip.buf[:0]
+		var err error
+		if rows, err = ip.addLine(rows, raw, int64(len(raw))); err != nil {
+			return rows, err
+		}
+	}
+	return ip.finishRow(rows)
+}
+
+// addLine processes a complete line of n bytes, appending the rows it
+// completes to rows.
+func (ip *IncrementalParser) addLine(rows []Row, raw string, n int64) ([]Row, error) {
+	ip.num++
+	l := line{num: ip.num, offset: ip.offset}
+	ip.offset += n
+	raw = strings.TrimSuffix(raw, "\r")
+	text := strings.TrimLeft(raw, " ")
+	l.indent, l.text = len(raw)-len(text), text
+	if l.indent == 0 && l.text == "" {
+		return rows, nil
+	}
+
+	var rowErr error
+	if ip.row != nil {
+		if l.indent > ip.row[0].indent {
+			ip.row = append(ip.row, l)
+			return rows, nil
+		}
+		rows, rowErr = ip.finishRow(rows)
+	}
+	rows, err := ip.startLine(rows, l)
+	if rowErr != nil {
+		return rows, rowErr
+	}
+	return rows, err
+}
+
+// startLine processes a line that is not part of a waiting row: a row of
+// the open table or a line outside tables.
+func (ip *IncrementalParser) startLine(rows []Row, l line) ([]Row, error) {
+	if t := ip.table; t != nil {
+		if l.indent > t.header.indent {
+			if t.rowIndent < 0 {
+				t.rowIndent = l.indent
+			}
+			ip.row = []line{l}
+			if len(splitCells(l.text)) == t.width || (t.width == 0 && l.text == "") {
+				return ip.finishRow(rows)
+			}
+			return rows, nil
+		}
+		ip.table = nil
+	}
+
+	// The line after the dash of a list item is read as the first line of
+	// the item's block, as parseListItem does.
+	for isListItem(l.text) {
+		raw := strings.TrimPrefix(strings.TrimPrefix(l.text, "-"), " ")
+		l.indent += len(l.text) - len(raw)
+		l.text = raw
+	}
+	for len(ip.keys) > 0 && ip.keys[len(ip.keys)-1].indent >= l.indent {
+		ip.keys = ip.keys[:len(ip.keys)-1]
+	}
+	switch key, rest, kind := splitKeyLine(l.text); kind {
+	case keyNested:
+		ip.keys = append(ip.keys, openKey{indent: l.indent, key: key})
+	case keyTable:
+		p := &parser{}
+		columns, err := p.parseSchema(rest, l)
+		if err != nil {
+			return rows, err
+		}
+		t := &openTable{name: ip.path(key), header: l, columns: columns, rowIndent: -1}
+		for _, col := range columns {
+			t.width += col.width()
+		}
+		ip.table = t
+	}
+	return rows, nil
+}
+
+// finishRow parses the row waiting for its nested blocks, if any.
+func (ip *IncrementalParser) finishRow(rows []Row) ([]Row, error) {
+	if ip.row == nil {
+		return rows, nil
+	}
+	lines, t := ip.row, ip.table
+	ip.row = nil
+
+	p := &parser{lines: lines, pos: 1}
+	node, err := p.parseRow(t.columns, lines[0])
+	if err == nil && p.pos < len(lines) {
+		next := lines[p.pos]
+		err = p.errorf(next, 0, "expected row indentation of %d spaces, got %d", lines[0].indent, next.indent)
+	}
+	if err == nil && lines[0].indent != t.rowIndent {
+		err = p.errorf(lines[0], 0, "expected row indentation of %d spaces, got %d", t.rowIndent, lines[0].indent)
+	}
+	if err != nil {
+		return rows, err
+	}
+
+	d := &decodeState{}
+	values, _ := d.toInterface(node).(map[string]interface{})
+	rows = append(rows, Row{Table: t.name, Index: t.rows, Values: values, node: node})
+	t.rows++
+	return rows, nil
+}
+
+// path returns the dotted path of key within the open "key:" blocks. The
+// empty key of a table that is a list item adds nothing to the path.
+func (ip *IncrementalParser) path(key string) string {
+	parts := make([]string, 0, len(ip.keys)+1)
+	for _, k := range ip.keys {
+		parts = append(parts, k.key)
+	}
+	if key != "" {
+		parts = append(parts, key)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,121 @@
+package jet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIncrementalParser(t *testing.T) {
+	type user struct {
+		ID      int               `jet:"id"`
+		Name    string            `jet:"name"`
+		Profile map[string]string `jet:"profile"`
+	}
+	input := "status: ok\nresult:\n users{id|name}:\n  1|Alice\n  2|Bob\n" +
+		"orders{id|items}:\n 7\n   > items{sku|qty}:\n   A1|2\n   B2|1\n 8\n   > items{sku|qty}:\n   C3|5\n"
+
+	p := NewIncrementalParser()
+	var rows []Row
+	// Feed the document one byte at a time, as a model would stream it
+	for i := 0; i < len(input); i++ {
+		got, err := p.Feed([]byte{input[i]})
+		if err != nil {
+			t.Fatalf("Feed failed at byte %d: %v", i, err)
+		}
+		rows = append(rows, got...)
+		if input[i] == '\n' && input[:i+1] == "status: ok\nresult:\n users{id|name}:\n  1|Alice\n" {
+			if len(rows) != 1 || rows[0].Values["name"] != "Alice" {
+				t.Errorf("Expected the first row at its newline, got %+v", rows)
+			}
+		}
+	}
+	if pending := p.Pending(); pending != " 8\n   > items{sku|qty}:\n   C3|5\n" {
+		t.Errorf("Expected the last order to be pending, got %q", pending)
+	}
+	last, err := p.Close()
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	rows = append(rows, last...)
+
+	var tables []string
+	for _, row := range rows {
+		tables = append(tables, row.Table)
+	}
+	if expected := []string{"result.users", "result.users", "orders", "orders"}; !reflect.DeepEqual(tables, expected) {
+		t.Errorf("Expected rows of %v, got %v", expected, tables)
+	}
+	items := []interface{}{map[string]interface{}{"sku": "C3", "qty": 5}}
+	if rows[3].Index != 1 || !reflect.DeepEqual(rows[3].Values, map[string]interface{}{"id": 8, "items": items}) {
+		t.Errorf("Unexpected last row %+v", rows[3])
+	}
+
+	var u user
+	if err := rows[1].Decode(&u); err != nil || u.ID != 2 || u.Name != "Bob" {
+		t.Errorf("Unexpected decoded row %+v, %v", u, err)
+	}
+}
+
+func TestIncrementalParserTruncated(t *testing.T) {
+	p := NewIncrementalParser()
+	rows, err := p.Feed([]byte("{id|name}:\n 1|Alice\n 2|Bo"))
+	if err != nil {
+		t.Fatalf("Feed failed: %v", err)
+	}
+	if len(rows) != 1 || p.Pending() != " 2|Bo" {
+		t.Errorf("Expected one row and a pending one, got %+v and %q", rows, p.Pending())
+	}
+
+	rows, err = p.Feed([]byte("b\n 3|Carol|extra\n 4|Dan\n 5"))
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Line != 4 {
+		t.Errorf("Expected *SyntaxError on line 4, got %v", err)
+	}
+	if len(rows) != 2 || rows[0].Values["name"] != "Bob" || rows[1].Values["name"] != "Dan" {
+		t.Errorf("Expected the rows around the bad one, got %+v", rows)
+	}
+
+	// A row cut short by the end of the stream is an error at Close
+	if _, err := p.Close(); !errors.As(err, &syntaxErr) {
+		t.Errorf("Expected *SyntaxError for the truncated row, got %v", err)
+	}
+}
+
+func TestIncrementalParserListItems(t *testing.T) {
+	input := "customers:\n" +
+		" - name: Alice\n   orders{id|qty}:\n    1|2\n    2|1\n" +
+		" - orders{id|qty}:\n    3|5\n" +
+		" - {sku|qty}:\n    A1|2\n"
+
+	var doc interface{}
+	if err := Unmarshal([]byte(input), &doc); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	p := NewIncrementalParser()
+	rows, err := p.Feed([]byte(input))
+	if err != nil {
+		t.Fatalf("Feed failed: %v", err)
+	}
+	last, err := p.Close()
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	rows = append(rows, last...)
+
+	var tables []string
+	for _, row := range rows {
+		tables = append(tables, row.Table)
+	}
+	if expected := []string{"customers.orders", "customers.orders", "customers.orders", "customers"}; !reflect.DeepEqual(tables, expected) {
+		t.Fatalf("Expected rows of %v, got %v", expected, tables)
+	}
+	if rows[2].Index != 0 || !reflect.DeepEqual(rows[2].Values, map[string]interface{}{"id": 3, "qty": 5}) {
+		t.Errorf("Unexpected row %+v", rows[2])
+	}
+	items := doc.(map[string]interface{})["customers"].([]interface{})
+	if !reflect.DeepEqual(rows[3].Values, items[2].([]interface{})[0]) {
+		t.Errorf("Expected %v as Unmarshal reads it, got %v", items[2], rows[3].Values)
+	}
+}
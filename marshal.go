@@ -1,44 +1,179 @@
 package jet
 
 import (
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func Marshal(v interface{}) ([]byte, error) {
-	return marshal(v)
+	return marshal(v, EncodeOptions{})
 }
 
 func MarshalFlattened(v interface{}) ([]byte, error) {
-	return marshalFlattened(v)
+	return marshal(v, EncodeOptions{Format: FormatFlattened})
 }
 
 func MarshalNormalized(v interface{}) ([]byte, error) {
-	return marshalNormalized(v)
+	return marshal(v, EncodeOptions{Format: FormatNormalized})
 }
 
-func marshalNormalized(v interface{}) ([]byte, error) {
-	genericData, err := encode(v)
-	if err != nil {
-		return nil, err
-	}
+// Format selects one of the three Jet layouts.
+type Format int
 
-	formattedBytes, err := formatNormalized(genericData)
-	if err != nil {
-		return nil, err
-	}
+const (
+	// FormatNormal writes nested objects as "> field:" blocks (Marshal).
+	FormatNormal Format = iota
+	// FormatFlattened inlines scalar-only nested objects into table rows
+	// (MarshalFlattened).
+	FormatFlattened
+	// FormatNormalized writes nested objects as pipe-only rows keyed by the
+	// table header (MarshalNormalized).
+	FormatNormalized
+)
 
-	return formattedBytes, nil
+// KeyOrder selects the order in which object keys and table columns are
+// written.
+type KeyOrder int
+
+const (
+	// SortedOrder writes keys alphabetically. It is the default.
+	SortedOrder KeyOrder = iota
+	// DeclarationOrder writes struct fields in the order they are declared,
+	// with promoted fields at the position of their embedded struct. Map
+	// keys are still sorted.
+	DeclarationOrder
+)
+
+// EncodeOptions configures MarshalWithOptions.
+type EncodeOptions struct {
+	Format   Format
+	KeyOrder KeyOrder
+
+	// Keys lists keys that are written first, in the given order, in every
+	// object and table that has them. The remaining keys follow in
+	// KeyOrder.
+	Keys []string
+
+	// MinKeyOverlap lets lists of objects with differing keys be written
+	// as tables. The header is the union of their keys and a row leaves
+	// the cells of its missing keys empty. A list is only written as a
+	// table when at least this share of the cells, between 0 and 1, is
+	// filled. Zero requires all objects to have the same keys.
+	MinKeyOverlap float64
+
+	// MaxDepth limits how many levels of nested objects and lists are
+	// written. Deeper objects and lists are replaced with the "..."
+	// elision marker. Zero means no limit.
+	MaxDepth int
+}
+
+// MarshalWithOptions is like Marshal but configurable: it writes any of the
+// three formats and lets the caller choose the order of keys and columns,
+// for example to put an id first and long free-text columns last.
+func MarshalWithOptions(v interface{}, opts EncodeOptions) ([]byte, error) {
+	return marshal(v, opts)
+}
+
+// Marshaler is the interface implemented by types that encode themselves.
+// MarshalJet returns a value that is encoded in place of the receiver,
+// typically a string so the type takes a single cell in a table.
+type Marshaler interface {
+	MarshalJet() (interface{}, error)
+}
+
+// Unmarshaler is the interface implemented by types that decode
+// themselves. UnmarshalJet receives the value as it would be decoded into
+// an interface{}: a map[string]interface{}, a []interface{}, a bool, int,
+// float64 or string, or nil for the null token.
+type Unmarshaler interface {
+	UnmarshalJet(value interface{}) error
+}
+
+// A MarshalerError is returned when a MarshalJet or MarshalText method
+// fails.
+type MarshalerError struct {
+	Type       reflect.Type
+	Err        error
+	sourceFunc string
+}
+
+func (e *MarshalerError) Error() string {
+	return "jet: error calling " + e.sourceFunc + " for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+func (e *MarshalerError) Unwrap() error { return e.Err }
+
+// An UnsupportedValueError is returned by Marshal when it is given a value
+// it cannot encode, such as a pointer graph with a cycle.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "jet: unsupported value: " + e.Str
+}
+
+// Unmarshal parses the Jet-encoded data and stores the result in the value
+// pointed to by v. Struct fields are matched using the same jet tags and
+// lowercased names as Marshal, falling back to a case-insensitive match.
+// When v holds an interface{}, scalars are decoded as bool, int, float64 or
+// string depending on their text. The null token "~" sets pointers,
+// interfaces, maps and slices to nil; an empty document is read as null.
+func Unmarshal(data []byte, v interface{}) error {
+	_, err := unmarshal(data, v, DecodeOptions{})
+	return err
 }
 
-func marshalFlattened(v interface{}) ([]byte, error) {
-	genericData, err := encode(v)
+// DecodeOptions configures UnmarshalWithOptions.
+type DecodeOptions struct {
+	// Lenient makes the parser recover from the mistakes common in
+	// model-written Jet: it strips ``` code fences and the prose around
+	// them, pads or truncates rows to the header width, accepts drifting
+	// indentation and skips lines it cannot parse.
+	Lenient bool
+}
+
+// UnmarshalWithOptions is like Unmarshal but configurable. In lenient mode
+// it returns the problems it recovered from as diagnostics alongside the
+// decoded value; the error is reserved for problems it could not recover
+// from, such as values of the wrong type.
+func UnmarshalWithOptions(data []byte, v interface{}, opts DecodeOptions) ([]*SyntaxError, error) {
+	return unmarshal(data, v, opts)
+}
+
+// UnmarshalNormalized parses data produced by MarshalNormalized, using the
+// "field{a|b}" sub-schemas of tabular headers to map pipe-only nested rows
+// back to their keys. Unmarshal detects these headers as well, so both
+// functions accept any Jet document.
+func UnmarshalNormalized(data []byte, v interface{}) error {
+	return Unmarshal(data, v)
+}
+
+// UnmarshalFlattened parses data produced by MarshalFlattened, expanding
+// "field{a,b}" header groups back into nested objects. Nested values that
+// MarshalFlattened replaced with "[nested]" or "[table]" are left unset and
+// reported through a *LossyFieldError once the rest of the document has
+// been decoded.
+func UnmarshalFlattened(data []byte, v interface{}) error {
+	return Unmarshal(data, v)
+}
+
+func marshal(v interface{}, opts EncodeOptions) ([]byte, error) {
+	e := &encodeState{opts: opts, visiting: map[visit]int{}}
+	genericData, err := e.encode(v)
 	if err != nil {
 		return nil, err
 	}
 
-	formattedBytes, err := formatFlattened(genericData)
+	formattedBytes, err := format(genericData, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -46,60 +181,198 @@ func marshalFlattened(v interface{}) ([]byte, error) {
 	return formattedBytes, nil
 }
 
-func Unmarshal(data []byte, v interface{}) error {
-	return unmarshal(data, v)
+// encodeState holds the state of a single Marshal call.
+type encodeState struct {
+	opts  EncodeOptions
+	path  []string // keys and indexes leading to the value being encoded
+	depth int      // number of objects and lists enclosing the value
+
+	// visiting maps the pointers, maps and slices being encoded to the
+	// length of the path where they were entered, to detect cycles.
+	visiting map[visit]int
 }
 
-func marshal(v interface{}) ([]byte, error) {
+// visit identifies a pointer, map or slice by its type and the memory it
+// refers to. Slices sharing an array but not their length differ.
+type visit struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
 
-	genericData, err := encode(v)
-	if err != nil {
-		return nil, err
+// elided is stored in place of an object or list nested deeper than
+// MaxDepth.
+type elided struct{}
+
+// encodeChild encodes a value found under key, keeping track of the path
+// and depth.
+func (e *encodeState) encodeChild(key string, v interface{}) (interface{}, error) {
+	e.path = append(e.path, key)
+	e.depth++
+	encoded, err := e.encode(v)
+	e.depth--
+	e.path = e.path[:len(e.path)-1]
+	return encoded, err
+}
+
+// enter records that the pointer, map or slice val is being encoded and
+// returns the key to delete from visiting when done. It fails when val is
+// already being encoded further up the path, as its encoding would never
+// end.
+func (e *encodeState) enter(val reflect.Value) (visit, error) {
+	key := visit{typ: val.Type(), ptr: val.Pointer()}
+	if val.Kind() == reflect.Slice {
+		key.len = val.Len()
 	}
+	if at, ok := e.visiting[key]; ok {
+		return key, &UnsupportedValueError{Value: val, Str: fmt.Sprintf(
+			"encountered a cycle via %s: %s refers back to %s", val.Type(), pathString(e.path), pathString(e.path[:at]))}
+	}
+	e.visiting[key] = len(e.path)
+	return key, nil
+}
 
-	formattedBytes, err := format(genericData)
-	if err != nil {
-		return nil, err
+// pathString renders an encoding path for error messages.
+func pathString(path []string) string {
+	if len(path) == 0 {
+		return "the root value"
 	}
+	return strings.Join(path, ".")
+}
 
-	return formattedBytes, nil
+// elide reports whether an object or list found at the current depth is
+// beyond MaxDepth.
+func (e *encodeState) elide() bool {
+	return e.opts.MaxDepth > 0 && e.depth >= e.opts.MaxDepth
+}
+
+func (e *encodeState) encode(v interface{}) (interface{}, error) {
+	return e.encodeValue(v, nil)
 }
 
-func encode(v interface{}) (interface{}, error) {
+// encodeValue encodes v, ignoring the MarshalJet method of plain, the type
+// with its pointers removed, whose own MarshalJet returned v.
+func (e *encodeState) encodeValue(v interface{}, plain reflect.Type) (interface{}, error) {
 	val := reflect.ValueOf(v)
 
-	if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+	// Nil pointers, interfaces, maps and slices are encoded as nil, which
+	// the writers print as the null token "~".
+	for {
+		if !val.IsValid() {
+			return nil, nil
+		}
+		if (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil() {
+			return nil, nil
+		}
+		if val.Kind() == reflect.Ptr {
+			key, err := e.enter(val)
+			if err != nil {
+				if e.elide() {
+					// The cycle is cut by MaxDepth.
+					return elided{}, nil
+				}
+				return nil, err
+			}
+			defer delete(e.visiting, key)
+		}
+		if base := baseType(val.Type()); base != plain {
+			if custom, ok, err := encodeMarshaler(val); ok {
+				if err != nil {
+					return nil, err
+				}
+				if t := reflect.TypeOf(custom); t != nil && baseType(t) == base {
+					// A MarshalJet returning its own type, typically the
+					// value of a pointer receiver, asks for the default
+					// encoding.
+					return e.encodeValue(custom, base)
+				}
+				return e.encode(custom)
+			}
+		}
+		if text, ok, err := encodeText(val); ok {
+			return text, err
+		}
+		if val.Kind() != reflect.Ptr && val.Kind() != reflect.Interface {
+			break
+		}
 		val = val.Elem()
 	}
 
 	switch val.Kind() {
 	case reflect.Struct:
-		resultMap := make(map[string]interface{})
-		t := val.Type()
-
-		for i := 0; i < val.NumField(); i++ {
-			field := t.Field(i)
-			fieldValue := val.Field(i)
+		if e.elide() {
+			return elided{}, nil
+		}
+		fields := cachedTypeFields(val.Type())
+		resultObj := newObject(len(fields))
 
-			tagName := field.Tag.Get("jet")
-			if tagName == "" {
-				tagName = strings.ToLower(field.Name)
+		var inlineMaps []reflect.Value
+		for _, f := range fields {
+			fieldValue, ok := fieldByIndex(val, f.index)
+			if !ok {
+				continue // Promoted through a nil embedded pointer
 			}
-			if tagName == "-" {
-				continue // Skip this field
+			if m := indirect(fieldValue); f.opts.Contains("inline") && m.Kind() == reflect.Map {
+				inlineMaps = append(inlineMaps, m)
+				continue
+			}
+			if f.opts.Contains("omitempty") && isEmptyValue(fieldValue) {
+				resultObj.set(f.name, omitted{})
+				continue
 			}
 
-			encodedValue, err := encode(fieldValue.Interface())
+			encodedValue, err := e.encodeField(f.name, fieldValue, f.opts)
 			if err != nil {
 				return nil, err
 			}
-			resultMap[tagName] = encodedValue
+			resultObj.set(f.name, encodedValue)
+		}
+
+		// Entries of inline maps never replace struct fields.
+		for _, m := range inlineMaps {
+			if m.IsNil() {
+				continue
+			}
+			entries, err := mapEntries(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if resultObj.has(entry.key) {
+					continue
+				}
+				encodedValue, err := e.encodeChild(entry.key, entry.value.Interface())
+				if err != nil {
+					return nil, err
+				}
+				resultObj.set(entry.key, encodedValue)
+			}
+		}
+		return resultObj, nil
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.IsNil() {
+			return nil, nil
+		}
+		if isBytes(val) {
+			// Byte slices and arrays are written as a single base64 value.
+			return base64.StdEncoding.EncodeToString(bytesOf(val)), nil
+		}
+		if e.elide() {
+			return elided{}, nil
+		}
+		if t := e.structTable(val); t != nil {
+			return t, nil
+		}
+		if val.Kind() == reflect.Slice && val.Len() > 0 {
+			key, err := e.enter(val)
+			if err != nil {
+				return nil, err
+			}
+			defer delete(e.visiting, key)
 		}
-		return resultMap, nil
-	case reflect.Slice:
 		resultSlice := make([]interface{}, val.Len())
 		for i := 0; i < val.Len(); i++ {
-			encodedValue, err := encode(val.Index(i).Interface())
+			encodedValue, err := e.encodeChild(strconv.Itoa(i), val.Index(i).Interface())
 			if err != nil {
 				return nil, err
 			}
@@ -107,24 +380,319 @@ func encode(v interface{}) (interface{}, error) {
 		}
 		return resultSlice, nil
 	case reflect.Map:
-		resultMap := make(map[string]interface{})
-		for _, key := range val.MapKeys() {
-			mapValue := val.MapIndex(key)
-			encodedValue, err := encode(mapValue.Interface())
+		if val.IsNil() {
+			return nil, nil
+		}
+		if e.elide() {
+			return elided{}, nil
+		}
+		key, err := e.enter(val)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(e.visiting, key)
+		entries, err := mapEntries(val)
+		if err != nil {
+			return nil, err
+		}
+		resultObj := newObject(len(entries))
+		resultObj.sorted = true
+		for _, entry := range entries {
+			encodedValue, err := e.encodeChild(entry.key, entry.value.Interface())
 			if err != nil {
 				return nil, err
 			}
-			resultMap[key.String()] = encodedValue
+			resultObj.set(entry.key, encodedValue)
 		}
-		return resultMap, nil
-	case reflect.String, reflect.Int, reflect.Int64, reflect.Int32, reflect.Float64, reflect.Float32, reflect.Bool:
-		return val.Interface(), nil
+		return resultObj, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// Kept as uint64 so values above 2^53 are written exactly.
+		return val.Uint(), nil
+	case reflect.String:
+		// Named string types are written as strings, and quoted as such.
+		return val.String(), nil
+	case reflect.Float32:
+		return float32(val.Float()), nil
+	case reflect.Float64:
+		return val.Float(), nil
+	case reflect.Bool:
+		return val.Bool(), nil
 	default:
 		return nil, fmt.Errorf("jet: unsupported type for marshaling %s", val.Kind())
 	}
 }
 
-func unmarshal(data []byte, v interface{}) error {
-	// Implementation of unmarshaling logic goes here
-	return nil
+var (
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// encodeText returns the text form of values written as a single scalar:
+// time.Time in RFC 3339, time.Duration as its String form and any type
+// implementing encoding.TextMarshaler, on a value or pointer receiver.
+func encodeText(val reflect.Value) (interface{}, bool, error) {
+	switch val.Type() {
+	case timeType:
+		return val.Interface().(time.Time).Format(time.RFC3339Nano), true, nil
+	case durationType:
+		return val.Interface().(time.Duration).String(), true, nil
+	}
+
+	val, ok := implementer(val, textMarshalerType)
+	if !ok {
+		return nil, false, nil
+	}
+	text, err := val.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return nil, true, &MarshalerError{Type: val.Type(), Err: err, sourceFunc: "MarshalText"}
+	}
+	return string(text), true, nil
+}
+
+// encodeMarshaler calls MarshalJet when val implements Marshaler, on a
+// value or pointer receiver.
+func encodeMarshaler(val reflect.Value) (interface{}, bool, error) {
+	val, ok := implementer(val, marshalerType)
+	if !ok {
+		return nil, false, nil
+	}
+	custom, err := val.Interface().(Marshaler).MarshalJet()
+	if err != nil {
+		return nil, true, &MarshalerError{Type: val.Type(), Err: err, sourceFunc: "MarshalJet"}
+	}
+	return custom, true, nil
+}
+
+// baseType returns t with its pointers removed.
+func baseType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// implementer returns val, or a pointer to a copy of it when the method set
+// of the pointer is needed, if either implements iface.
+func implementer(val reflect.Value, iface reflect.Type) (reflect.Value, bool) {
+	if val.Kind() == reflect.Interface {
+		return val, false
+	}
+	if val.Type().Implements(iface) {
+		return val, true
+	}
+	if !reflect.PointerTo(val.Type()).Implements(iface) {
+		return val, false
+	}
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	return ptr, true
+}
+
+// mapEntry is a map element with its key converted to a Jet key.
+type mapEntry struct {
+	key   string
+	value reflect.Value
+	sort  reflect.Value // the original key, for numeric and bool ordering
+}
+
+// mapEntries returns the elements of the map val in a deterministic order.
+// String keys are used as is; encoding.TextMarshaler, integer, unsigned and
+// bool keys are converted to their text form. Numeric keys are sorted by
+// value, bool keys false first and all other keys by their text.
+func mapEntries(val reflect.Value) ([]mapEntry, error) {
+	entries := make([]mapEntry, 0, val.Len())
+	iter := val.MapRange()
+	for iter.Next() {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, mapEntry{key: key, value: iter.Value(), sort: iter.Key()})
+	}
+
+	kind := val.Type().Key().Kind()
+	if val.Type().Key().Implements(textMarshalerType) && kind != reflect.String {
+		kind = reflect.String // Sorted by text
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].sort, entries[j].sort
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		}
+		return entries[i].key < entries[j].key
+	})
+	return entries, nil
+}
+
+// mapKeyString converts a map key to the string written as its Jet key.
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if tm, ok := key.Interface().(encoding.TextMarshaler); ok {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", nil
+		}
+		text, err := tm.MarshalText()
+		if err != nil {
+			return "", &MarshalerError{Type: key.Type(), Err: err, sourceFunc: "MarshalText"}
+		}
+		return string(text), nil
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(key.Bool()), nil
+	}
+	return "", fmt.Errorf("jet: unsupported map key type %s", key.Type())
+}
+
+// omitted is stored in place of a field tagged omitempty whose value is
+// empty. Objects leave the field out; tables keep its column, writing an
+// empty cell, unless the field is omitted in every row.
+type omitted struct{}
+
+// isEmptyValue reports whether v is empty in the sense of omitempty: false,
+// 0, "", a nil pointer or interface, or an array, map, slice or string of
+// length zero.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// encodeField encodes the value of the struct field named name, applying
+// the format and string options of its tag.
+func (e *encodeState) encodeField(name string, fieldValue reflect.Value, opts tagOptions) (interface{}, error) {
+	v := indirect(fieldValue)
+	format := opts.Get("format")
+	switch {
+	case isBytes(v) && (format == "hex" || opts.Contains("hex")):
+		return hex.EncodeToString(bytesOf(v)), nil
+	case v.Kind() == reflect.Struct && v.Type() == timeType && format != "":
+		return formatTime(v.Interface().(time.Time), format), nil
+	}
+
+	encodedValue, err := e.encodeChild(name, fieldValue.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if opts.Contains("string") {
+		switch encodedValue.(type) {
+		case int64, uint64, float32, float64, bool:
+			encodedValue = fmt.Sprint(encodedValue)
+		}
+	}
+	return encodedValue, nil
+}
+
+// timeLayouts holds the layouts named by the format tag option.
+var timeLayouts = map[string]string{
+	"rfc3339":  time.RFC3339Nano,
+	"date":     time.DateOnly,
+	"datetime": time.DateTime,
+	"time":     time.TimeOnly,
+}
+
+// formatTime writes t in the format named by a format tag option: a named
+// layout, unix or unixmilli for an integer timestamp, or a Go time layout.
+func formatTime(t time.Time, format string) interface{} {
+	switch format {
+	case "unix":
+		return t.Unix()
+	case "unixmilli":
+		return t.UnixMilli()
+	}
+	if layout, ok := timeLayouts[format]; ok {
+		format = layout
+	}
+	return t.Format(format)
+}
+
+// parseTime is the inverse of formatTime. Integer timestamps are read as
+// UTC.
+func parseTime(s, format string) (time.Time, error) {
+	switch format {
+	case "unix", "unixmilli":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == "unix" {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.UnixMilli(n).UTC(), nil
+	}
+	if layout, ok := timeLayouts[format]; ok {
+		format = layout
+	}
+	return time.Parse(format, s)
+}
+
+// fieldName returns the Jet key of a struct field: the name in its jet tag,
+// or the lowercased field name. It reports false for fields tagged "-".
+func fieldName(field reflect.StructField) (string, bool) {
+	tagName, _ := parseTag(field.Tag.Get("jet"))
+	if tagName == "" {
+		tagName = strings.ToLower(field.Name)
+	}
+	if tagName == "-" {
+		return "", false
+	}
+	return tagName, true
+}
+
+// indirect follows pointers until it reaches a non-pointer or nil pointer.
+func indirect(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	return val
+}
+
+// isBytes reports whether val is a non-nil slice or an array of bytes.
+func isBytes(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Slice:
+		return !val.IsNil() && val.Type().Elem().Kind() == reflect.Uint8
+	case reflect.Array:
+		return val.Type().Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// bytesOf copies the elements of a byte slice or array, which may use a
+// named byte type, into a []byte.
+func bytesOf(val reflect.Value) []byte {
+	b := make([]byte, val.Len())
+	for i := range b {
+		b[i] = byte(val.Index(i).Uint())
+	}
+	return b
 }
@@ -1,6 +1,8 @@
 package jet
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -568,3 +570,66 @@ func TestMarshalFlattenedNonFlattenable(t *testing.T) {
 		t.Errorf("Expected non-empty output")
 	}
 }
+
+func TestMarshalFlattenedMixedNestedPointers(t *testing.T) {
+	mixed := []testCustomer{
+		{ID: 1, Site: testSite{City: "a", Geo: &testGeo{Lat: 1, Lng: 2}}, Billing: &testSite{City: "x"}},
+		{ID: 2, Site: testSite{City: "b"}},
+	}
+
+	// A nil *testSite in some rows still shares the column group
+	plain := []testCustomer{{ID: 1, Billing: &testSite{City: "x"}}, {ID: 2}, {ID: 3, Billing: &testSite{City: "y"}}}
+	result, err := MarshalFlattened(plain)
+	if err != nil {
+		t.Fatalf("MarshalFlattened failed: %v", err)
+	}
+	if !strings.Contains(string(result), "billing{city,geo}") {
+		t.Errorf("Expected a billing group in the header, got:\n%s", result)
+	}
+	var decoded []testCustomer
+	if err := Unmarshal(result, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, result)
+	}
+	if !reflect.DeepEqual(decoded, plain) {
+		t.Errorf("Expected %+v, got %+v\n%s", plain, decoded, result)
+	}
+
+	// A nested pointer set in some rows only keeps the column in one layout
+	for _, customers := range [][]testCustomer{mixed, {mixed[1], mixed[0]}} {
+		result, err := MarshalFlattened(customers)
+		if err != nil {
+			t.Fatalf("MarshalFlattened failed: %v", err)
+		}
+		var decoded []testCustomer
+		err = Unmarshal(result, &decoded)
+		var lossyErr *LossyFieldError
+		if !errors.As(err, &lossyErr) {
+			t.Fatalf("Expected only a *LossyFieldError for the unflattenable site, got %v\n%s", err, result)
+		}
+		for i := range customers {
+			if decoded[i].ID != customers[i].ID || !reflect.DeepEqual(decoded[i].Billing, customers[i].Billing) {
+				t.Errorf("Row %d: expected %+v, got %+v", i, customers[i], decoded[i])
+			}
+		}
+	}
+
+	// Map rows with different keys are written by the union of the keys
+	rows := []map[string]interface{}{
+		{"id": 1, "profile": map[string]interface{}{"a": 1, "b": 2}},
+		{"id": 2, "profile": map[string]interface{}{"a": 1, "c": 3}},
+	}
+	result, err = MarshalFlattened(rows)
+	if err != nil {
+		t.Fatalf("MarshalFlattened failed: %v", err)
+	}
+	var maps []map[string]interface{}
+	if err := Unmarshal(result, &maps); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, result)
+	}
+	if !reflect.DeepEqual(maps, []map[string]interface{}{
+		{"id": 1, "profile": map[string]interface{}{"a": 1, "b": 2}},
+		{"id": 2, "profile": map[string]interface{}{"a": 1, "c": 3}},
+	}) {
+		t.Errorf("Unexpected round trip %v\n%s", maps, result)
+	}
+}
@@ -1,6 +1,7 @@
 package jet
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -473,3 +474,63 @@ func TestMarshalNormalizedVsFlattened(t *testing.T) {
 			len(normalizedResult), len(normalResult))
 	}
 }
+
+func TestMarshalNormalizedMixedNestedPointers(t *testing.T) {
+	mixed := []testCustomer{
+		{ID: 1, Site: testSite{City: "a", Geo: &testGeo{Lat: 1, Lng: 2}}, Billing: &testSite{City: "x"}},
+		{ID: 2, Site: testSite{City: "b"}},
+	}
+
+	// A nil *testSite in some rows still shares the column group
+	plain := []testCustomer{{ID: 1, Billing: &testSite{City: "x"}}, {ID: 2}, {ID: 3, Billing: &testSite{City: "y"}}}
+	result, err := MarshalNormalized(plain)
+	if err != nil {
+		t.Fatalf("MarshalNormalized failed: %v", err)
+	}
+	if !strings.Contains(string(result), "billing{city|geo}") {
+		t.Errorf("Expected a billing group in the header, got:\n%s", result)
+	}
+	var decoded []testCustomer
+	if err := Unmarshal(result, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, result)
+	}
+	if !reflect.DeepEqual(decoded, plain) {
+		t.Errorf("Expected %+v, got %+v\n%s", plain, decoded, result)
+	}
+
+	// A nested pointer set in some rows only keeps the column in one layout
+	for _, customers := range [][]testCustomer{mixed, {mixed[1], mixed[0]}} {
+		result, err := MarshalNormalized(customers)
+		if err != nil {
+			t.Fatalf("MarshalNormalized failed: %v", err)
+		}
+		var decoded []testCustomer
+		err = Unmarshal(result, &decoded)
+		if err != nil {
+			t.Fatalf("Unmarshal failed: %v\n%s", err, result)
+		}
+		if !reflect.DeepEqual(decoded, customers) {
+			t.Errorf("Expected %+v, got %+v\n%s", customers, decoded, result)
+		}
+	}
+
+	// Map rows with different keys are written by the union of the keys
+	rows := []map[string]interface{}{
+		{"id": 1, "profile": map[string]interface{}{"a": 1, "b": 2}},
+		{"id": 2, "profile": map[string]interface{}{"a": 1, "c": 3}},
+	}
+	result, err = MarshalNormalized(rows)
+	if err != nil {
+		t.Fatalf("MarshalNormalized failed: %v", err)
+	}
+	var maps []map[string]interface{}
+	if err := Unmarshal(result, &maps); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, result)
+	}
+	if !reflect.DeepEqual(maps, []map[string]interface{}{
+		{"id": 1, "profile": map[string]interface{}{"a": 1, "b": 2}},
+		{"id": 2, "profile": map[string]interface{}{"a": 1, "c": 3}},
+	}) {
+		t.Errorf("Unexpected round trip %v\n%s", maps, result)
+	}
+}
@@ -1,8 +1,14 @@
 package jet
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMarshalSimpleStruct(t *testing.T) {
@@ -357,9 +363,16 @@ func TestMarshalEmptySlice(t *testing.T) {
 		t.Fatalf("Marshal failed: %v", err)
 	}
 
-	// Empty slice should produce empty or minimal output
-	if len(result) > 0 {
-		t.Logf("Empty slice output: %s", string(result))
+	if string(result) != "[]\n" {
+		t.Errorf("Expected %q, got %q", "[]\n", result)
+	}
+
+	var decoded []Product
+	if err := Unmarshal(result, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded == nil || len(decoded) != 0 {
+		t.Errorf("Expected an empty, non-nil slice, got %#v", decoded)
 	}
 }
 
@@ -447,3 +460,1002 @@ func TestMarshalMixedTypes(t *testing.T) {
 		t.Errorf("Expected 'boolfield: true'")
 	}
 }
+
+func TestMarshalQuotesSpecialValues(t *testing.T) {
+	type Row struct {
+		Name        string
+		Description string
+	}
+
+	result, err := Marshal([]Row{
+		{Name: "a|b", Description: "first line\nsecond line"},
+		{Name: "> not a block", Description: "plain text"},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	resultStr := string(result)
+	t.Logf("Quoted values output:\n%s", resultStr)
+
+	if !strings.Contains(resultStr, `"first line\nsecond line"|"a|b"`) {
+		t.Errorf("Expected pipe and newline values to be quoted")
+	}
+	if !strings.Contains(resultStr, `plain text|"> not a block"`) {
+		t.Errorf("Expected leading '>' value to be quoted and plain text left bare")
+	}
+	if strings.Count(resultStr, "\n") != 3 {
+		t.Errorf("Expected header and two rows on three lines")
+	}
+}
+
+func TestMarshalNil(t *testing.T) {
+	type Address struct {
+		City string
+	}
+
+	type Person struct {
+		Name    string
+		Address *Address
+		Extra   interface{}
+		Labels  map[string]string
+		Tags    []string
+	}
+
+	result, err := Marshal(Person{Name: "~"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	resultStr := string(result)
+	t.Logf("Nil fields output:\n%s", resultStr)
+
+	for _, expected := range []string{"address: ~", "extra: ~", "labels: ~", "tags: ~", `name: "~"`} {
+		if !strings.Contains(resultStr, expected) {
+			t.Errorf("Expected %q in output", expected)
+		}
+	}
+
+	result, err = Marshal((*Person)(nil))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(result) != "~\n" {
+		t.Errorf("Expected nil pointer to marshal as \"~\\n\", got %q", result)
+	}
+}
+
+func TestMarshalNumericKinds(t *testing.T) {
+	type Status uint16
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"int", struct{ V int }{-42}, "v: -42"},
+		{"int8", struct{ V int8 }{-128}, "v: -128"},
+		{"int16", struct{ V int16 }{-32768}, "v: -32768"},
+		{"int32", struct{ V int32 }{-2147483648}, "v: -2147483648"},
+		{"int64", struct{ V int64 }{-9223372036854775808}, "v: -9223372036854775808"},
+		{"uint", struct{ V uint }{42}, "v: 42"},
+		{"uint8", struct{ V uint8 }{255}, "v: 255"},
+		{"uint16", struct{ V uint16 }{65535}, "v: 65535"},
+		{"uint32", struct{ V uint32 }{4294967295}, "v: 4294967295"},
+		{"uint64 above 2^53", struct{ V uint64 }{1<<53 + 1}, "v: 9007199254740993"},
+		{"uint64 max", struct{ V uint64 }{18446744073709551615}, "v: 18446744073709551615"},
+		{"uintptr", struct{ V uintptr }{4096}, "v: 4096"},
+		{"named uint16", struct{ V Status }{404}, "v: 404"},
+		{"float32", struct{ V float32 }{2.5}, "v: 2.5"},
+		{"float64", struct{ V float64 }{-0.125}, "v: -0.125"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+
+			resultStr := string(result)
+			if strings.TrimSpace(resultStr) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, resultStr)
+			}
+		})
+	}
+}
+
+func TestMarshalArraysAndBytes(t *testing.T) {
+	type Record struct {
+		ID       [4]byte
+		Checksum []byte `jet:"sum,hex"`
+		Payload  []byte
+		Vector   [3]float64
+	}
+
+	result, err := Marshal(Record{
+		ID:       [4]byte{0xde, 0xad, 0xbe, 0xef},
+		Checksum: []byte{0x01, 0xab},
+		Payload:  []byte("hello"),
+		Vector:   [3]float64{1, 2.5, -3},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	resultStr := string(result)
+	t.Logf("Arrays and bytes output:\n%s", resultStr)
+
+	for _, expected := range []string{"id: 3q2+7w==", "sum: 01ab", "payload: aGVsbG8=", "vector: [1,2.5,-3]"} {
+		if !strings.Contains(resultStr, expected) {
+			t.Errorf("Expected %q in output", expected)
+		}
+	}
+}
+
+type testLevel int
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"low", "high"}[l]), nil
+}
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+func TestMarshalTextTypes(t *testing.T) {
+	type Event struct {
+		At      time.Time
+		Timeout time.Duration
+		Level   testLevel
+		Addr    net.IP
+	}
+
+	result, err := Marshal(Event{
+		At:      time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		Timeout: 90 * time.Second,
+		Level:   1,
+		Addr:    net.ParseIP("10.0.0.1"),
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	resultStr := string(result)
+	t.Logf("Text types output:\n%s", resultStr)
+
+	for _, expected := range []string{"at: 2024-03-01T09:30:00Z", "timeout: 1m30s", "level: high", "addr: 10.0.0.1"} {
+		if !strings.Contains(resultStr, expected) {
+			t.Errorf("Expected %q in output", expected)
+		}
+	}
+}
+
+type testMoney struct {
+	Cents    int64
+	Currency string
+}
+
+func (m testMoney) MarshalJet() (interface{}, error) {
+	if m.Currency == "" {
+		return nil, errors.New("missing currency")
+	}
+	return fmt.Sprintf("%d.%02d %s", m.Cents/100, m.Cents%100, m.Currency), nil
+}
+
+func (m *testMoney) UnmarshalJet(value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected money string, got %T", value)
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(s, "%d.%d %s", &units, &cents, &m.Currency); err != nil {
+		return err
+	}
+	m.Cents = units*100 + cents
+	return nil
+}
+
+type testPoint struct {
+	Lat, Lng float64
+}
+
+func (p *testPoint) MarshalJet() (interface{}, error) {
+	return map[string]float64{"lat": p.Lat, "lng": p.Lng}, nil
+}
+
+func TestMarshalCustomMarshaler(t *testing.T) {
+	type LineItem struct {
+		SKU      string
+		Price    testMoney
+		Location testPoint
+	}
+
+	items := []LineItem{
+		{SKU: "A-1", Price: testMoney{Cents: 1250, Currency: "USD"}, Location: testPoint{Lat: 52.5, Lng: 13.4}},
+		{SKU: "B-2", Price: testMoney{Cents: 99, Currency: "EUR"}, Location: testPoint{Lat: 48.8, Lng: 2.3}},
+	}
+
+	result, err := MarshalFlattened(items)
+	if err != nil {
+		t.Fatalf("MarshalFlattened failed: %v", err)
+	}
+
+	resultStr := string(result)
+	t.Logf("Custom marshaler output:\n%s", resultStr)
+
+	if !strings.Contains(resultStr, "{location{lat,lng}|price|sku}:") {
+		t.Errorf("Expected price as a single column in header")
+	}
+	if !strings.Contains(resultStr, "52.5|13.4|12.50 USD|A-1") {
+		t.Errorf("Expected price as a single cell")
+	}
+
+	_, err = Marshal(LineItem{SKU: "C-3"})
+	var marshalerErr *MarshalerError
+	if !errors.As(err, &marshalerErr) {
+		t.Fatalf("Expected *MarshalerError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "MarshalJet") || !strings.Contains(err.Error(), "missing currency") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
+
+// testDefaulted falls back to the default encoding by returning its own
+// value type, after dropping its secret.
+type testDefaulted struct {
+	Name   string
+	Secret string
+}
+
+func (d *testDefaulted) MarshalJet() (interface{}, error) {
+	return testDefaulted{Name: d.Name}, nil
+}
+
+// testSelf returns a pointer to itself.
+type testSelf struct {
+	ID int
+}
+
+func (s *testSelf) MarshalJet() (interface{}, error) {
+	return s, nil
+}
+
+func TestMarshalCustomMarshalerOwnType(t *testing.T) {
+	value := struct {
+		Item  testDefaulted
+		Ptr   *testDefaulted
+		Self  testSelf
+		Items []testDefaulted
+	}{
+		Item:  testDefaulted{Name: "a", Secret: "x"},
+		Ptr:   &testDefaulted{Name: "b", Secret: "y"},
+		Self:  testSelf{ID: 3},
+		Items: []testDefaulted{{Name: "c", Secret: "z"}},
+	}
+
+	result, err := Marshal(value)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := "item:\n name: a\n secret: \"\"\nitems{name|secret}:\n c|\"\"\nptr:\n name: b\n secret: \"\"\nself:\n id: 3\n"
+	if string(result) != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+type testTimestamps struct {
+	Created string
+	updated string
+}
+
+type testAudit struct {
+	Author string
+}
+
+func TestMarshalEmbeddedAndUnexported(t *testing.T) {
+	type Base struct {
+		ID     int
+		secret string
+	}
+
+	type Document struct {
+		Base
+		*testAudit
+		testTimestamps
+		Title  string
+		hidden int
+		Meta   struct {
+			Version int
+			draft   bool
+		}
+	}
+
+	doc := Document{
+		Base:           Base{ID: 7, secret: "s3cret"},
+		testTimestamps: testTimestamps{Created: "2024-01-01", updated: "never"},
+		Title:          "Spec",
+		hidden:         42,
+	}
+	doc.Meta.Version = 2
+
+	result, err := Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	resultStr := string(result)
+	t.Logf("Embedded output:\n%s", resultStr)
+
+	for _, expected := range []string{"id: 7\n", "created: 2024-01-01\n", "title: Spec\n", "meta:\n version: 2\n"} {
+		if !strings.Contains(resultStr, expected) {
+			t.Errorf("Expected %q in output", expected)
+		}
+	}
+	for _, unexpected := range []string{"base", "secret", "updated", "hidden", "draft", "author", "testaudit"} {
+		if strings.Contains(resultStr, unexpected) {
+			t.Errorf("Did not expect %q in output", unexpected)
+		}
+	}
+
+	doc.testAudit = &testAudit{Author: "Ann"}
+	result, err = Marshal([]Document{doc})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(result), "{author|created|id|meta|title}:") {
+		t.Errorf("Expected promoted columns in header, got:\n%s", result)
+	}
+
+	// A tag name on an unexported embedded struct cannot make it a field
+	type Tagged struct {
+		testTimestamps `jet:"times"`
+		Y              int
+	}
+	result, err = Marshal(Tagged{testTimestamps: testTimestamps{Created: "2024-01-01"}, Y: 1})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(result) != "y: 1\n" {
+		t.Errorf("Expected the tagged unexported struct to be skipped, got %q", result)
+	}
+}
+
+func TestMarshalEmbeddedConflicts(t *testing.T) {
+	type A struct{ Name, Kind string }
+	type B struct{ Name, Kind string }
+	type C struct {
+		Kind string `jet:"kind"`
+	}
+	type Deep struct{ A }
+	type Meta struct{ Version, Owner string }
+
+	tests := []struct {
+		name       string
+		value      interface{}
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "shallower field wins",
+			value: struct {
+				Deep
+				Name string
+			}{Deep{A{Name: "deep", Kind: "k"}}, "outer"},
+			expected:   []string{"name: outer", "kind: k"},
+			unexpected: []string{"deep"},
+		},
+		{
+			name: "same depth conflict is dropped",
+			value: struct {
+				A
+				B
+			}{A{Name: "a", Kind: "ka"}, B{Name: "b", Kind: "kb"}},
+			unexpected: []string{"name", "kind"},
+		},
+		{
+			name: "tagged field wins at same depth",
+			value: struct {
+				A
+				C
+			}{A{Name: "a", Kind: "from a"}, C{Kind: "from c"}},
+			expected:   []string{"name: a", "kind: from c"},
+			unexpected: []string{"from a"},
+		},
+		{
+			name: "named embedded struct is nested",
+			value: struct {
+				A     `jet:"base"`
+				Title string
+			}{A{Name: "a", Kind: "k"}, "t"},
+			expected: []string{"base:\n kind: k\n name: a\n", "title: t"},
+		},
+		{
+			name: "inline struct field is promoted",
+			value: struct {
+				Meta  Meta `jet:",inline"`
+				Title string
+			}{Meta{Version: "v2", Owner: "ann"}, "t"},
+			expected:   []string{"version: v2", "owner: ann", "title: t"},
+			unexpected: []string{"meta"},
+		},
+		{
+			name: "inline pointer field is promoted",
+			value: struct {
+				Meta *Meta `jet:",inline"`
+			}{&Meta{Version: "v3"}},
+			expected:   []string{"version: v3"},
+			unexpected: []string{"meta"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+
+			resultStr := string(result)
+			t.Logf("Output:\n%s", resultStr)
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(resultStr, expected) {
+					t.Errorf("Expected %q in output", expected)
+				}
+			}
+			for _, unexpected := range tt.unexpected {
+				if strings.Contains(resultStr, unexpected) {
+					t.Errorf("Did not expect %q in output", unexpected)
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalTagOptions(t *testing.T) {
+	type Dims struct {
+		W int `jet:"w,omitempty"`
+		H int
+	}
+
+	type Product struct {
+		ID     int               `jet:"id,string"`
+		Name   string            `jet:"name"`
+		Note   string            `jet:"note,omitempty"`
+		Stock  int               `jet:"stock,omitempty"`
+		Tags   []string          `jet:",omitempty"`
+		Hash   []byte            `jet:"hash,format=hex"`
+		Dims   Dims              `jet:"dims"`
+		Added  time.Time         `jet:"added,format=date"`
+		Extras map[string]string `jet:",inline"`
+	}
+
+	added := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	products := []Product{
+		{ID: 1, Name: "Laptop", Stock: 5, Hash: []byte{0xab}, Dims: Dims{W: 3, H: 2}, Added: added},
+		{ID: 2, Name: "Mouse", Hash: []byte{0xcd}, Dims: Dims{H: 1}, Added: added},
+	}
+
+	tests := []struct {
+		name     string
+		marshal  func(interface{}) ([]byte, error)
+		expected []string
+	}{
+		{"Marshal", Marshal, []string{
+			"{added|dims|hash|id|name|stock}:\n",
+			` 2024-05-06|ab|"1"|Laptop|5` + "\n",
+			"   > dims:\n  h: 2\n  w: 3\n",
+			` 2024-05-06|cd|"2"|Mouse|` + "\n",
+			"   > dims:\n  h: 1\n",
+		}},
+		{"MarshalFlattened", MarshalFlattened, []string{
+			"{added|dims{h,w}|hash|id|name|stock}:\n",
+			` 2024-05-06|2|3|ab|"1"|Laptop|5` + "\n",
+			` 2024-05-06|1||cd|"2"|Mouse|` + "\n",
+		}},
+		{"MarshalNormalized", MarshalNormalized, []string{
+			"{added|dims{h|w}|hash|id|name|stock}:\n",
+			"   > dims:\n   2|3\n",
+			"   > dims:\n   1|\n",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.marshal(products)
+			if err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+
+			resultStr := string(result)
+			t.Logf("Output:\n%s", resultStr)
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(resultStr, expected) {
+					t.Errorf("Expected %q in output", expected)
+				}
+			}
+			if strings.Contains(resultStr, "note") || strings.Contains(resultStr, "tags") || strings.Contains(resultStr, "extras") {
+				t.Errorf("Expected columns empty in every row to be dropped")
+			}
+		})
+	}
+
+	result, err := Marshal(Product{Name: "Cable", Note: "spare", Extras: map[string]string{"color": "red", "name": "ignored"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	resultStr := string(result)
+	t.Logf("Single object output:\n%s", resultStr)
+	for _, expected := range []string{"name: Cable\n", "note: spare\n", "color: red\n"} {
+		if !strings.Contains(resultStr, expected) {
+			t.Errorf("Expected %q in output", expected)
+		}
+	}
+	if strings.Contains(resultStr, "stock") || strings.Contains(resultStr, "ignored") {
+		t.Errorf("Unexpected output:\n%s", resultStr)
+	}
+}
+
+func TestMarshalKeyOrder(t *testing.T) {
+	type Base struct {
+		ID int
+	}
+
+	type Customer struct {
+		Name  string
+		Email string
+	}
+
+	type Order struct {
+		Base
+		Status      string
+		Customer    Customer
+		Description string
+	}
+
+	orders := []Order{
+		{Base: Base{ID: 1}, Status: "open", Customer: Customer{Name: "Ann", Email: "ann@example.com"}, Description: "Two laptops"},
+		{Base: Base{ID: 2}, Status: "closed", Customer: Customer{Name: "Bob", Email: "bob@example.com"}, Description: "A mouse"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     EncodeOptions
+		expected []string
+	}{
+		{
+			name:     "sorted by default",
+			opts:     EncodeOptions{},
+			expected: []string{"{customer|description|id|status}:\n", "   > customer:\n  email: ann@example.com\n  name: Ann\n"},
+		},
+		{
+			name:     "declaration order",
+			opts:     EncodeOptions{KeyOrder: DeclarationOrder},
+			expected: []string{"{id|status|customer|description}:\n", " 1|open|Two laptops\n", "   > customer:\n  name: Ann\n  email: ann@example.com\n"},
+		},
+		{
+			name:     "declaration order flattened",
+			opts:     EncodeOptions{Format: FormatFlattened, KeyOrder: DeclarationOrder},
+			expected: []string{"{id|status|customer{name,email}|description}:\n", " 1|open|Ann|ann@example.com|Two laptops\n"},
+		},
+		{
+			name:     "declaration order normalized",
+			opts:     EncodeOptions{Format: FormatNormalized, KeyOrder: DeclarationOrder},
+			expected: []string{"{id|status|customer{name|email}|description}:\n", "   > customer:\n   Ann|ann@example.com\n"},
+		},
+		{
+			name:     "explicit list before sorted keys",
+			opts:     EncodeOptions{Keys: []string{"id", "email"}},
+			expected: []string{"{id|customer|description|status}:\n", "   > customer:\n  email: ann@example.com\n  name: Ann\n"},
+		},
+		{
+			name:     "explicit list before declaration order",
+			opts:     EncodeOptions{KeyOrder: DeclarationOrder, Keys: []string{"description", "missing", "status"}},
+			expected: []string{"{description|status|id|customer}:\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := MarshalWithOptions(orders, tt.opts)
+			if err != nil {
+				t.Fatalf("MarshalWithOptions failed: %v", err)
+			}
+
+			resultStr := string(result)
+			t.Logf("Output:\n%s", resultStr)
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(resultStr, expected) {
+					t.Errorf("Expected %q in output", expected)
+				}
+			}
+
+			var decoded []Order
+			if err := Unmarshal(result, &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, orders) {
+				t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", orders, decoded)
+			}
+		})
+	}
+
+	result, err := MarshalWithOptions(map[string]int{"b": 2, "a": 1}, EncodeOptions{KeyOrder: DeclarationOrder})
+	if err != nil {
+		t.Fatalf("MarshalWithOptions failed: %v", err)
+	}
+	if string(result) != "a: 1\nb: 2\n" {
+		t.Errorf("Expected map keys sorted, got %q", result)
+	}
+}
+
+func TestMarshalNonStringMapKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"int", map[int]string{10: "ten", 2: "two", -1: "minus one"}, "-1: minus one\n2: two\n10: ten\n"},
+		{"uint64", map[uint64]int{1 << 63: 1, 7: 2}, "7: 2\n9223372036854775808: 1\n"},
+		{"bool", map[bool]string{true: "yes", false: "no"}, "false: no\ntrue: yes\n"},
+		{"text marshaler", map[testLevel]int{1: 5, 0: 3}, "high: 5\nlow: 3\n"},
+		{"int8 in struct", struct{ Counts map[int8]int }{map[int8]int{3: 1, -3: 2}}, "counts:\n -3: 2\n 3: 1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	if _, err := Marshal(map[float64]string{1.5: "x"}); err == nil {
+		t.Errorf("Expected error for float map keys")
+	}
+}
+
+func TestMarshalInlineLists(t *testing.T) {
+	type post struct {
+		ID   int      `jet:"id"`
+		Tags []string `jet:"tags"`
+	}
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"key line", struct {
+			Tags []string `jet:"tags"`
+		}{[]string{"go", "jet", "llm"}}, "tags: [go,jet,llm]\n"},
+		{"empty", struct {
+			Tags []string `jet:"tags"`
+		}{[]string{}}, "tags: []\n"},
+		{"escaped items", struct {
+			Tags []string `jet:"tags"`
+		}{[]string{"a,b", "x]", "two words", "p|q", "42"}}, `tags: ["a,b","x]",two words,"p|q","42"]` + "\n"},
+		{"placeholder word", struct {
+			Tags []string `jet:"tags"`
+		}{[]string{"nested"}}, "tags: [\"nested\"]\n"},
+		{"mixed scalars", struct {
+			Values []interface{} `jet:"values"`
+		}{[]interface{}{1, true, nil, "x"}}, "values: [1,true,~,x]\n"},
+		{"table cells", []post{{1, []string{"go", "a|b"}}, {2, nil}}, "{id|tags}:\n 1|[go,\"a|b\"]\n 2|~\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestMarshalSparseTables(t *testing.T) {
+	events := []map[string]interface{}{
+		{"id": 1, "type": "click", "x": 10, "y": 20},
+		{"id": 2, "type": "key", "key": "a"},
+		{"id": 3, "type": "click", "x": 5, "y": 7},
+	}
+
+	result, err := MarshalWithOptions(events, EncodeOptions{MinKeyOverlap: 0.5})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	t.Logf("Sparse table output:\n%s", result)
+	expected := "{id|key|type|x|y}:\n 1||click|10|20\n 2|a|key||\n 3||click|5|7\n"
+	if string(result) != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	// Below the threshold the list is not written as a table
+	result, err = MarshalWithOptions(events, EncodeOptions{MinKeyOverlap: 0.9})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if strings.Contains(string(result), "{id|") {
+		t.Errorf("Expected no table below the overlap threshold, got:\n%s", result)
+	}
+
+	// Declaration order merges the keys of every row
+	type click struct {
+		ID int `jet:"id"`
+		X  int `jet:"x"`
+	}
+	type key struct {
+		ID  int    `jet:"id"`
+		Key string `jet:"key"`
+		Mod string `jet:"mod"`
+	}
+	result, err = MarshalWithOptions([]interface{}{click{1, 10}, key{2, "a", "shift"}}, EncodeOptions{
+		KeyOrder:      DeclarationOrder,
+		MinKeyOverlap: 0.5,
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.HasPrefix(string(result), "{id|key|mod|x}:\n") {
+		t.Errorf("Expected union header in declaration order, got:\n%s", result)
+	}
+}
+
+func TestMarshalListItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"scalars", []interface{}{1, "a", nil}, "- 1\n- a\n- ~\n"},
+		{"objects", []interface{}{
+			map[string]interface{}{"id": 1, "name": "Alice"},
+			map[string]interface{}{"sku": "A1"},
+		}, "- id: 1\n  name: Alice\n- sku: A1\n"},
+		{"nested lists", []interface{}{[]int{1, 2}, []interface{}{"x", map[string]int{"y": 1}}}, "- [1,2]\n- - x\n  - y: 1\n"},
+		{"table", []interface{}{"rows", []map[string]int{{"a": 1}, {"a": 2}}}, "- rows\n- {a}:\n   1\n   2\n"},
+		{"empty object", []interface{}{map[string]int{}, 1}, "-\n- 1\n"},
+		{"under a key", map[string]interface{}{
+			"events": []interface{}{map[string]interface{}{"at": 1, "tags": []string{"a"}}, "done"},
+		}, "events:\n - at: 1\n   tags: [a]\n - done\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestMarshalMatrices(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"top level", [][]float64{{0.1, 0.2, 0.3}, {0.4, 0.5, 0.6}}, "[2x3]:\n 0.1|0.2|0.3\n 0.4|0.5|0.6\n"},
+		{"under a key", struct {
+			Grid [][]string `jet:"grid"`
+		}{[][]string{{"a", "b|c"}, {"", "~"}}}, "grid[2x2]:\n a|\"b|c\"\n \"\"|\"~\"\n"},
+		{"ragged", map[string][][]int{"tri": {{1}, {2, 3}, {}}}, "tri[3x*]:\n 1\n 2|3\n \n"},
+		{"quoted key", map[string][][]int{"m[0]": {{1}}}, "\"m[0]\"[1x1]:\n 1\n"},
+		{"list item", []interface{}{"scores", [][]int{{1, 2}}}, "- scores\n- [1x2]:\n   1|2\n"},
+		{"all empty", [][]int{{}, {}}, "- []\n- []\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+type testNode struct {
+	Name     string      `jet:"name"`
+	Parent   *testNode   `jet:"parent"`
+	Children []*testNode `jet:"children"`
+}
+
+func TestMarshalCycles(t *testing.T) {
+	root := &testNode{Name: "root"}
+	child := &testNode{Name: "child", Parent: root}
+	root.Children = []*testNode{child}
+
+	_, err := Marshal(root)
+	var unsupported *UnsupportedValueError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected *UnsupportedValueError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "children.0.parent refers back to the root value") {
+		t.Errorf("Expected cycle path in error, got %q", err)
+	}
+
+	m := map[string]interface{}{"name": "loop"}
+	m["self"] = map[string]interface{}{"inner": m}
+	if _, err := Marshal(m); !errors.As(err, &unsupported) || !strings.Contains(err.Error(), "self.inner refers back") {
+		t.Errorf("Expected cycle through maps, got %v", err)
+	}
+
+	s := []interface{}{1, nil}
+	s[1] = s
+	if _, err := Marshal(s); !errors.As(err, &unsupported) {
+		t.Errorf("Expected cycle through slices, got %v", err)
+	}
+
+	// A value shared without a cycle is written each time it is reached
+	shared := &testNode{Name: "leaf"}
+	dag := []*testNode{{Name: "a", Children: []*testNode{shared}}, {Name: "b", Children: []*testNode{shared}}}
+	if _, err := Marshal(dag); err != nil {
+		t.Errorf("Expected shared pointers to marshal, got %v", err)
+	}
+}
+
+func TestMarshalMaxDepth(t *testing.T) {
+	value := map[string]interface{}{
+		"id":   1,
+		"tags": []string{"a"},
+		"user": map[string]interface{}{
+			"name":    "Alice",
+			"address": map[string]interface{}{"city": "Paris"},
+			"roles":   []string{"admin"},
+		},
+	}
+
+	result, err := MarshalWithOptions(value, EncodeOptions{MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := "id: 1\ntags: [a]\nuser:\n address: ...\n name: Alice\n roles: ...\n"
+	if string(result) != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	result, err = MarshalWithOptions(value, EncodeOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if expected := "id: 1\ntags: ...\nuser: ...\n"; string(result) != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	// The marker also stops cycles
+	root := &testNode{Name: "root"}
+	root.Children = []*testNode{{Name: "child", Parent: root}}
+	if _, err := MarshalWithOptions(root, EncodeOptions{MaxDepth: 3}); err != nil {
+		t.Errorf("Expected MaxDepth to cut the cycle, got %v", err)
+	}
+
+	// The string "..." is quoted to tell it apart from the marker
+	result, err = Marshal(map[string]string{"note": "..."})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(result) != "note: \"...\"\n" {
+		t.Errorf("Expected quoted ellipsis, got %q", result)
+	}
+}
+
+type testStatus string
+
+type testKey uint64
+
+type tableRow struct {
+	ID      int
+	Key     testKey
+	Status  testStatus
+	Name    string
+	Score   float64
+	Ratio   float32
+	Count   uint8
+	Active  bool
+	Code    int       `jet:"code,string"`
+	Flag    bool      `jet:"flag,string"`
+	Note    string    `jet:"note,omitempty"`
+	Unused  int       `jet:"unused,omitempty"`
+	Day     time.Time `jet:"day,format=date"`
+	Stamp   time.Time `jet:"stamp,format=unix,string"`
+	Created time.Time
+}
+
+func TestMarshalStructTables(t *testing.T) {
+	day := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	rows := []tableRow{
+		{ID: 1, Name: "Alice", Score: 1e21, Ratio: 0.1, Count: 255, Active: true, Code: 7, Note: "hi", Day: day, Stamp: day, Created: day, Key: 1 << 60, Status: "open"},
+		{ID: -2, Name: "true", Score: math.Inf(-1), Ratio: 1.5, Flag: true, Day: day, Created: day, Status: "true"},
+		{ID: 3, Name: "a|b", Score: math.NaN(), Ratio: float32(math.Inf(1)), Note: "[x]", Created: day},
+		{ID: 4, Name: "", Score: 0.000001, Note: "12", Key: 9, Status: "12"},
+	}
+	ptrs := make([]*tableRow, len(rows))
+	for i := range rows {
+		ptrs[i] = &rows[i]
+	}
+	value := map[string]interface{}{"rows": rows, "nested": []interface{}{rows[:2]}}
+	generic := map[string]interface{}{"rows": ptrs, "nested": []interface{}{ptrs[:2]}}
+
+	// Tables of plain structs are written directly from their fields; the
+	// pointers go through the generic encoder, which must agree.
+	if cachedStructEncoder(reflect.TypeOf(tableRow{})).cells == nil {
+		t.Fatal("Expected tableRow to be written directly")
+	}
+	for _, opts := range []EncodeOptions{
+		{},
+		{Format: FormatFlattened},
+		{Format: FormatNormalized},
+		{KeyOrder: DeclarationOrder, Keys: []string{"name", "id"}},
+	} {
+		result, err := MarshalWithOptions(value, opts)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		expected, err := MarshalWithOptions(generic, opts)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if string(result) != string(expected) {
+			t.Errorf("With %+v expected\n%s\ngot\n%s", opts, expected, result)
+		}
+	}
+
+	result, err := Marshal(rows[3:])
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := "{active|code|count|created|day|flag|id|key|name|note|ratio|score|stamp|status}:\n" +
+		" false|\"0\"|0|0001-01-01T00:00:00Z|0001-01-01|\"false\"|4|9|\"\"|\"12\"|0|1e-06|-62135596800|\"12\"\n"
+	if string(result) != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	var decoded []tableRow
+	if err := Unmarshal(result, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0].ID != 4 || decoded[0].Note != "12" || decoded[0].Score != 0.000001 || decoded[0].Status != "12" || decoded[0].Key != 9 {
+		t.Errorf("Unexpected round trip: %+v", decoded)
+	}
+}
+
+type testGeo struct {
+	Lat, Lng float64
+}
+
+type testSite struct {
+	City string
+	Geo  *testGeo
+}
+
+type testCustomer struct {
+	ID      int
+	Site    testSite
+	Billing *testSite
+}
@@ -0,0 +1,1084 @@
+package jet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A SyntaxError describes malformed Jet input. Its message has the form
+// "expected X, got Y" so it can be handed back to whoever produced the
+// document, such as a language model asked to correct its output.
+type SyntaxError struct {
+	Msg    string // description of the problem
+	Line   int    // 1-based line number
+	Column int    // 1-based byte column within the line
+	Offset int64  // byte offset from the start of the input
+	Text   string // the offending line, without its line ending
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("jet: syntax error at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// line is a single physical line of a Jet document split into its
+// indentation and content.
+type line struct {
+	num    int    // 1-based line number
+	offset int64  // byte offset of the start of the line
+	indent int    // number of leading spaces
+	text   string // content after the indentation
+}
+
+type parser struct {
+	lines []line
+	pos   int
+
+	// In lenient mode recoverable problems are collected in diagnostics
+	// instead of aborting the parse.
+	lenient     bool
+	diagnostics []*SyntaxError
+}
+
+// quotedString is a scalar that was written in quotes. Unlike bare scalars
+// it always decodes as a string.
+type quotedString string
+
+// cell is a raw table cell and its position within the row text.
+type cell struct {
+	text string
+	pos  int
+}
+
+// column is a single entry of a tabular header. Columns written as
+// "name{a|b}" by MarshalNormalized or "name{a,b}" by MarshalFlattened carry
+// the keys of their nested object.
+type column struct {
+	name string
+	sub  []string
+	flat bool // sub-values are spliced into the row itself
+}
+
+func newParser(data []byte, lenient bool) *parser {
+	p := &parser{lenient: lenient}
+	var offset int64
+	for i, raw := range strings.Split(string(data), "\n") {
+		next := offset + int64(len(raw)) + 1
+		raw = strings.TrimSuffix(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		p.lines = append(p.lines, line{
+			num:    i + 1,
+			offset: offset,
+			indent: len(raw) - len(text),
+			text:   text,
+		})
+		offset = next
+	}
+	if lenient {
+		p.stripCodeFences()
+	}
+	return p
+}
+
+// parse turns a Jet document into a generic tree made of
+// map[string]interface{}, []interface{} and string scalars. It returns nil
+// for an empty document. In lenient mode it also returns the problems it
+// recovered from.
+func parse(data []byte, lenient bool) (interface{}, []*SyntaxError, error) {
+	p := newParser(data, lenient)
+	result, err := p.parseDocument()
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(p.diagnostics, func(i, j int) bool {
+		return p.diagnostics[i].Offset < p.diagnostics[j].Offset
+	})
+	return result, p.diagnostics, nil
+}
+
+// errorf returns a *SyntaxError for the byte at position pos of l.text.
+func (p *parser) errorf(l line, pos int, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Msg:    fmt.Sprintf(format, args...),
+		Line:   l.num,
+		Column: l.indent + pos + 1,
+		Offset: l.offset + int64(l.indent+pos),
+		Text:   strings.Repeat(" ", l.indent) + l.text,
+	}
+}
+
+// recover records err as a diagnostic and reports whether parsing may
+// continue, which is only the case in lenient mode.
+func (p *parser) recover(err error) bool {
+	if !p.lenient {
+		return false
+	}
+	p.diagnostics = append(p.diagnostics, err.(*SyntaxError))
+	return true
+}
+
+// stripCodeFences blanks out ``` fence lines and, when the document is
+// wrapped in a fenced block, the prose surrounding it. Lines are blanked
+// rather than removed so diagnostics keep their line numbers.
+func (p *parser) stripCodeFences() {
+	var fences []int
+	for i, l := range p.lines {
+		if strings.HasPrefix(l.text, "```") {
+			fences = append(fences, i)
+		}
+	}
+	if len(fences) == 0 {
+		return
+	}
+
+	start, end := fences[0]+1, len(p.lines)
+	if len(fences) > 1 {
+		end = fences[1]
+	}
+	for i := range p.lines {
+		if i >= start && i < end {
+			continue
+		}
+		l := p.lines[i]
+		if l.text != "" {
+			if i == fences[0] || (len(fences) > 1 && i == fences[1]) {
+				p.recover(p.errorf(l, 0, "expected Jet, got code fence %q", l.text))
+			} else {
+				p.recover(p.errorf(l, 0, "expected Jet, got text outside the code fence %q", l.text))
+			}
+		}
+		p.lines[i].indent, p.lines[i].text = 0, ""
+	}
+}
+
+// peek returns the next line that is not completely empty. Lines holding
+// only spaces are returned, since they may be rows of a table whose cells
+// are all nested.
+func (p *parser) peek() (line, bool) {
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent == 0 && l.text == "" {
+			p.pos++
+			continue
+		}
+		return l, true
+	}
+	return line{}, false
+}
+
+func (p *parser) parseDocument() (interface{}, error) {
+	first, ok := p.peek()
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch {
+	case isListItem(first.text):
+		result, err = p.parseList(first.indent)
+	case isKeyLine(first.text):
+		switch key, rest, kind := splitKeyLine(first.text); {
+		case kind == keyTable && key == "":
+			p.pos++
+			result, err = p.parseTable(rest, first, false)
+		case kind == keyMatrix && key == "":
+			p.pos++
+			result, err = p.parseMatrix(rest, first, false)
+		default:
+			// A first line indented deeper than the rest is drifting
+			// indentation, not the end of the document.
+			result, err = p.parseMap(p.minIndent())
+		}
+	case p.moreText(p.pos+1) || strings.HasSuffix(first.text, ":"):
+		// A scalar document is a single line, and never ends with an
+		// unquoted colon: this is a malformed key line.
+		err = p.errorf(first, 0, "expected \"key: value\", \"key:\" or \"key{...}:\", got %q", first.text)
+		if !p.recover(err) {
+			return nil, err
+		}
+		p.pos++
+		result, err = p.parseMap(p.minIndent())
+	default:
+		p.pos++
+		result, err = p.scalar(first, 0, first.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		l, ok := p.peek()
+		if !ok {
+			return result, nil
+		}
+		if l.text != "" {
+			if err := p.errorf(l, 0, "expected end of document, got %q", l.text); !p.recover(err) {
+				return nil, err
+			}
+		}
+		p.pos++
+	}
+}
+
+// moreText reports whether a line from index i on holds any text.
+func (p *parser) moreText(i int) bool {
+	for _, l := range p.lines[i:] {
+		if l.text != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// minIndent returns the smallest indentation of the lines left to parse.
+func (p *parser) minIndent() int {
+	indent := -1
+	for _, l := range p.lines[p.pos:] {
+		if l.text != "" && (indent < 0 || l.indent < indent) {
+			indent = l.indent
+		}
+	}
+	return max(indent, 0)
+}
+
+// parseMap reads "key: value", "key:" and "key{schema}:" entries that sit
+// at the given indentation.
+func (p *parser) parseMap(indent int) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for {
+		l, ok := p.peek()
+		if !ok || l.indent < indent || isMarker(l.text) {
+			return result, nil
+		}
+		if l.text == "" {
+			p.pos++
+			continue
+		}
+		if l.indent > indent {
+			// Drifting indentation is read as belonging to this level.
+			if err := p.errorf(l, 0, "expected indentation of %d spaces, got %d", indent, l.indent); !p.recover(err) {
+				return nil, err
+			}
+		}
+
+		key, rest, kind := splitKeyLine(l.text)
+		p.pos++
+		switch kind {
+		case keyScalar:
+			value, err := p.scalar(l, len(l.text)-len(rest), rest)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		case keyTable:
+			rows, err := p.parseTable(rest, l, false)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rows
+		case keyMatrix:
+			rows, err := p.parseMatrix(rest, l, false)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rows
+		case keyNested:
+			value, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		default:
+			if err := p.errorf(l, 0, "expected \"key: value\", \"key:\" or \"key{...}:\", got %q", l.text); !p.recover(err) {
+				return nil, err
+			}
+		}
+	}
+}
+
+// parseNested reads the block below a "key:" line. A block without deeper
+// lines is an empty object.
+func (p *parser) parseNested(indent int) (interface{}, error) {
+	l, ok := p.peek()
+	if !ok || l.indent <= indent || l.text == "" || isMarker(l.text) {
+		return map[string]interface{}{}, nil
+	}
+	if isListItem(l.text) {
+		return p.parseList(l.indent)
+	}
+	return p.parseMap(l.indent)
+}
+
+// parseList reads "- item" lines that sit at the given indentation.
+func (p *parser) parseList(indent int) ([]interface{}, error) {
+	result := []interface{}{}
+	for {
+		l, ok := p.peek()
+		if !ok || l.indent != indent || !isListItem(l.text) {
+			return result, nil
+		}
+		item, err := p.parseListItem(l)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+}
+
+// parseListItem reads the item of the "- " line l. A key line or another
+// item after the dash starts a block whose lines are indented past the
+// dash; a lone dash is an object written on the lines below it, or an
+// empty one.
+func (p *parser) parseListItem(l line) (interface{}, error) {
+	raw := strings.TrimPrefix(strings.TrimPrefix(l.text, "-"), " ")
+	if raw == "" {
+		p.pos++
+		return p.parseNested(l.indent)
+	}
+	if !isListItem(raw) && !isKeyLine(raw) {
+		p.pos++
+		return p.scalar(l, len(l.text)-len(raw), raw)
+	}
+
+	// The block starts on the line of the dash: read that line as if it
+	// were the first line of the block.
+	first := l
+	first.indent += len(l.text) - len(raw)
+	first.text = raw
+	p.lines[p.pos] = first
+	if isListItem(raw) {
+		return p.parseList(first.indent)
+	}
+	switch key, rest, kind := splitKeyLine(raw); {
+	case kind == keyTable && key == "":
+		p.pos++
+		return p.parseTable(rest, first, false)
+	case kind == keyMatrix && key == "":
+		p.pos++
+		return p.parseMatrix(rest, first, false)
+	}
+	return p.parseMap(first.indent)
+}
+
+// scalar interprets the raw text of a value found at position pos of l.
+// Quoted text is unescaped into a quotedString, "[a,b]" is an inline list
+// and the elision marker a placeholder; anything else is a bare string
+// whose type is decided when decoding.
+func (p *parser) scalar(l line, pos int, raw string) (interface{}, error) {
+	if raw == nullToken {
+		return nil, nil
+	}
+	if raw == elisionMarker {
+		return placeholder(raw), nil
+	}
+	if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+		return p.inlineList(l, pos, raw)
+	}
+	if !strings.HasPrefix(raw, "\"") {
+		return raw, nil
+	}
+	end := quoteEnd(raw, 0)
+	if end == len(raw) {
+		if s, err := strconv.Unquote(raw); err == nil {
+			return quotedString(s), nil
+		}
+	}
+
+	var err error
+	if end < 0 {
+		err = p.errorf(l, pos+len(raw), "expected closing quote, got end of line")
+	} else if end < len(raw) {
+		err = p.errorf(l, pos+end, "expected end of quoted value, got %q", raw[end:])
+	} else {
+		err = p.errorf(l, pos, "expected valid escape sequences, got %s", raw)
+	}
+	if !p.recover(err) {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// inlineList parses the "[a,b,c]" form of a list of scalars found at
+// position pos of l. Items are read like any other scalar, so they may be
+// quoted or null.
+func (p *parser) inlineList(l line, pos int, raw string) ([]interface{}, error) {
+	items := []interface{}{}
+	inner := raw[1 : len(raw)-1]
+	if strings.TrimSpace(inner) == "" {
+		return items, nil
+	}
+
+	offset := pos + 1
+	for _, part := range splitList(inner) {
+		text := strings.TrimLeft(part, " ")
+		item, err := p.scalar(l, offset+len(part)-len(text), strings.TrimRight(text, " "))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		offset += len(part) + 1
+	}
+	return items, nil
+}
+
+// parseTable reads the rows following a tabular header. Rows of a table
+// declared by a key sit one level deeper than the header, while rows of a
+// table opened by a "> " sigil sit at the same indentation as the sigil.
+func (p *parser) parseTable(schema string, header line, marker bool) ([]interface{}, error) {
+	columns, err := p.parseSchema(schema, header)
+	if err != nil {
+		return nil, err
+	}
+
+	minIndent := header.indent + 1
+	if marker {
+		minIndent = header.indent
+	}
+	width := 0
+	for _, col := range columns {
+		width += col.width()
+	}
+
+	rows := []interface{}{}
+	rowIndent := -1
+	for {
+		l, ok := p.peek()
+		if !ok || isMarker(l.text) {
+			break
+		}
+		if l.indent < minIndent {
+			// Models often forget to indent rows; in lenient mode anything
+			// that cannot be a key at the header's level is taken as a row.
+			if !p.lenient || l.indent < header.indent || l.text == "" || isKeyLine(l.text) || isListItem(l.text) {
+				break
+			}
+			if width > 1 && len(splitCells(l.text)) < 2 {
+				// Without a pipe the line is more likely prose after the
+				// table, such as a closing remark, and ends it.
+				p.recover(p.errorf(l, 0, "expected a table row, got %q", l.text))
+				p.pos++
+				break
+			}
+			want := minIndent
+			if rowIndent >= 0 {
+				want = rowIndent
+			}
+			p.recover(p.errorf(l, 0, "expected row indentation of %d spaces, got %d", want, l.indent))
+		} else if rowIndent < 0 {
+			rowIndent = l.indent
+		} else if l.indent != rowIndent {
+			if err := p.errorf(l, 0, "expected row indentation of %d spaces, got %d", rowIndent, l.indent); !p.recover(err) {
+				break
+			}
+		}
+
+		p.pos++
+		row, err := p.parseRow(columns, l)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseMatrix reads the rows of a matrix declared with the given "RxC"
+// dimensions. Every row is a list of the scalars between its pipes; a row
+// holding only indentation is an empty list.
+func (p *parser) parseMatrix(dims string, header line, marker bool) ([]interface{}, error) {
+	count, cols, _ := matrixDims(dims)
+	minIndent := header.indent + 1
+	if marker {
+		minIndent = header.indent
+	}
+
+	rows := make([]interface{}, 0, count)
+	rowIndent := -1
+	for len(rows) < count {
+		l, ok := p.peek()
+		if !ok || l.indent < minIndent || isMarker(l.text) {
+			got := "end of document"
+			if ok {
+				got = fmt.Sprintf("%q", l.text)
+			}
+			err := p.errorf(header, len(header.text)-1, "expected %d matrix rows, got %d before %s", count, len(rows), got)
+			if !p.recover(err) {
+				return nil, err
+			}
+			break
+		}
+		if rowIndent < 0 {
+			rowIndent = l.indent
+		} else if l.indent != rowIndent && l.text != "" {
+			if err := p.errorf(l, 0, "expected row indentation of %d spaces, got %d", rowIndent, l.indent); !p.recover(err) {
+				return nil, err
+			}
+		}
+		p.pos++
+
+		row := []interface{}{}
+		if l.text != "" {
+			cells := splitCells(l.text)
+			if cols >= 0 && len(cells) != cols {
+				err := p.errorf(l, cellOffset(l.text, cells, cols), "expected %d cells, got %d", cols, len(cells))
+				if !p.recover(err) {
+					return nil, err
+				}
+				cells = fitCells(cells, cols, len(l.text))
+			}
+			for _, c := range cells {
+				value, err := p.scalar(l, c.pos, c.text)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, value)
+			}
+		} else if cols > 0 {
+			err := p.errorf(l, 0, "expected %d cells, got an empty row", cols)
+			if !p.recover(err) {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (p *parser) parseSchema(schema string, header line) ([]column, error) {
+	columns := []column{} // An empty schema declares a table without columns
+	if schema == "" {
+		return columns, nil
+	}
+	pos := len(header.text) - len(schema) - 2
+	for _, part := range splitOutside(schema, '|', true) {
+		name := part
+		if open := indexOutside(part, '{'); open >= 0 {
+			if !strings.HasSuffix(part, "}") || matchingBrace(part, open) != len(part)-1 {
+				err := p.errorf(header, pos+open, "expected \"}\" closing column %q, got %q", part[:open], part[open:])
+				if !p.recover(err) {
+					return nil, err
+				}
+				pos += len(part) + 1
+				continue
+			}
+			name = part[:open]
+		}
+
+		col := column{name: unquoteKey(name)}
+		if name != part {
+			col.sub = []string{}
+			inner := part[len(name)+1 : len(part)-1]
+			sep := byte('|')
+			if indexOutside(inner, ',') >= 0 {
+				sep = ',' // Flattened group
+				col.flat = true
+			}
+			if inner != "" {
+				for _, key := range splitOutside(inner, sep, false) {
+					col.sub = append(col.sub, unquoteKey(key))
+				}
+			}
+		}
+		if col.name == "" {
+			err := p.errorf(header, pos, "expected column name, got %q", part)
+			if !p.recover(err) {
+				return nil, err
+			}
+			pos += len(part) + 1
+			continue
+		}
+		columns = append(columns, col)
+		pos += len(part) + 1
+	}
+	return columns, nil
+}
+
+// splitOutside splits s on the occurrences of sep that are not inside a
+// quoted string or, when braces is set, a nested "{...}" group.
+func splitOutside(s string, sep byte, braces bool) []string {
+	delims := string(sep)
+	if braces {
+		delims = schemaDelims
+	}
+
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case opensQuote(s, i, delims):
+			if end := quoteEnd(s, i); end > 0 {
+				i = end - 1
+			}
+		case braces && c == '{':
+			depth++
+		case braces && c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitRow splits a table row into cells on the pipes that are not inside a
+// quoted value or an inline list, whose quoted items follow '[' or ','.
+func splitRow(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		delims := "|"
+		if depth > 0 {
+			delims = listDelims
+		}
+		switch c := s[i]; {
+		case opensQuote(s, i, delims):
+			if end := quoteEnd(s, i); end > 0 {
+				i = end - 1
+			}
+		case c == '[' && i == start:
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case c == '|' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitList splits the items of an inline list, without its brackets, on
+// the commas that are not inside a quoted item.
+func splitList(s string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case opensQuote(s, i, listDelims):
+			if end := quoteEnd(s, i); end > 0 {
+				i = end - 1
+			}
+		case s[i] == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// listDelims are the characters that may precede a quoted list item. A
+// space is accepted for lists written as "[a, b]".
+const listDelims = "[, "
+
+// indexOutside returns the index of the first c in a schema that is not
+// inside a quoted name, or -1.
+func indexOutside(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == c:
+			return i
+		case opensQuote(s, i, schemaDelims):
+			if end := quoteEnd(s, i); end > 0 {
+				i = end - 1
+			}
+		}
+	}
+	return -1
+}
+
+// schemaDelims are the characters that may precede a quoted column name.
+const schemaDelims = "{|,"
+
+// opensQuote reports whether s[i] starts a quoted value. Only a quote at the
+// start of s or right after one of delims does: bare values that merely
+// contain a quote are written as they are.
+func opensQuote(s string, i int, delims string) bool {
+	return s[i] == '"' && (i == 0 || strings.IndexByte(delims, s[i-1]) >= 0)
+}
+
+// quoteEnd returns the index just past the string quoted at s[start], or -1
+// when the closing quote is missing.
+func quoteEnd(s string, start int) int {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// unquoteKey returns the text of a key or column name, which is quoted when
+// it contains characters that delimit keys.
+func unquoteKey(s string) string {
+	if strings.HasPrefix(s, "\"") {
+		if key, err := strconv.Unquote(s); err == nil {
+			return key
+		}
+	}
+	return s
+}
+
+// parseRow reads a pipe-delimited row together with the "> field:" blocks
+// that follow it. Columns holding nested blocks are left out of the row,
+// so the cells are matched against the remaining columns in order. An empty
+// cell, as written for an omitted field, leaves its key out of the row.
+func (p *parser) parseRow(columns []column, row line) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(columns))
+
+	for {
+		l, ok := p.peek()
+		if !ok || l.indent <= row.indent || !isMarker(l.text) {
+			break
+		}
+		p.pos++
+
+		key, rest, kind := splitKeyLine(strings.TrimPrefix(l.text, "> "))
+		col, ok := findColumn(columns, key)
+		if !ok {
+			// A block for an undeclared column is still kept, since struct
+			// decoding ignores keys it does not know.
+			err := p.errorf(l, 2, "expected one of the columns %s, got %q", columnNames(columns), key)
+			if !p.recover(err) {
+				return nil, err
+			}
+		}
+		switch kind {
+		case keyTable:
+			rows, err := p.parseTable(rest, l, true)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rows
+		case keyMatrix:
+			rows, err := p.parseMatrix(rest, l, true)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rows
+		case keyNested:
+			if next, ok := p.peek(); ok && col.sub != nil && next.indent >= l.indent && !isMarker(next.text) {
+				// Normalized object: a single pipe-only row keyed by the
+				// sub-schema of the header.
+				p.pos++
+				value, err := p.parseSubRow(col, next)
+				if err != nil {
+					return nil, err
+				}
+				result[key] = value
+				continue
+			}
+			value, err := p.parseNested(row.indent)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		default:
+			if err := p.errorf(l, 0, "expected \"> field:\" or \"> field{...}:\", got %q", l.text); !p.recover(err) {
+				return nil, err
+			}
+		}
+	}
+
+	var cellColumns []column
+	width := 0
+	for _, col := range columns {
+		if _, nested := result[col.name]; !nested {
+			cellColumns = append(cellColumns, col)
+			width += col.width()
+		}
+	}
+
+	cells := splitCells(row.text)
+	if width == 0 && len(cells) == 1 && cells[0].text == "" {
+		return result, nil
+	}
+	if len(cells) != width {
+		err := p.errorf(row, cellOffset(row.text, cells, width), "expected %d cells, got %d", width, len(cells))
+		if !p.recover(err) {
+			return nil, err
+		}
+		cells = fitCells(cells, width, len(row.text))
+	}
+	for _, col := range cellColumns {
+		if col.inline() {
+			obj := make(map[string]interface{}, len(col.sub))
+			null, absent := true, true
+			for _, key := range col.sub {
+				text := cells[0].text
+				if text != "" {
+					value, err := p.scalar(row, cells[0].pos, text)
+					if err != nil {
+						return nil, err
+					}
+					obj[key] = value
+					null = null && value == nil
+					absent = false
+				}
+				cells = cells[1:]
+			}
+			switch {
+			case absent && len(col.sub) > 0:
+				// A group of empty cells stands for an omitted object.
+			case null && len(obj) > 0:
+				// A group of null tokens stands for a null object.
+				result[col.name] = nil
+			default:
+				result[col.name] = obj
+			}
+			continue
+		}
+		if cells[0].text != "" {
+			value, err := p.cellValue(row, cells[0])
+			if err != nil {
+				return nil, err
+			}
+			result[col.name] = value
+		}
+		cells = cells[1:]
+	}
+	return result, nil
+}
+
+// inline reports whether the nested object of col is spliced into the row.
+// A group with fewer than two keys has no separator to tell the Flattened
+// and Normalized forms apart, but a Normalized object is always written in
+// its own block, so a group reaching the row is a flattened one.
+func (col column) inline() bool {
+	return col.flat || (col.sub != nil && len(col.sub) < 2)
+}
+
+// width returns the number of row cells taken by col.
+func (col column) width() int {
+	if col.inline() {
+		return len(col.sub)
+	}
+	return 1
+}
+
+// cellValue interprets a table cell, turning the placeholders written by
+// MarshalFlattened for values it could not inline into placeholder nodes.
+func (p *parser) cellValue(row line, c cell) (interface{}, error) {
+	switch c.text {
+	case "[nested]", "[table]":
+		return placeholder(c.text), nil
+	}
+	return p.scalar(row, c.pos, c.text)
+}
+
+// parseSubRow maps the cells of a normalized nested row onto the keys
+// declared for col in the header.
+func (p *parser) parseSubRow(col column, row line) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(col.sub))
+	cells := splitCells(row.text)
+	if len(col.sub) == 0 && len(cells) == 1 && cells[0].text == "" {
+		return result, nil
+	}
+	if len(cells) != len(col.sub) {
+		err := p.errorf(row, cellOffset(row.text, cells, len(col.sub)), "expected %d cells for %q, got %d", len(col.sub), col.name, len(cells))
+		if !p.recover(err) {
+			return nil, err
+		}
+		cells = fitCells(cells, len(col.sub), len(row.text))
+	}
+	for i, key := range col.sub {
+		if cells[i].text == "" {
+			continue // Omitted
+		}
+		value, err := p.scalar(row, cells[i].pos, cells[i].text)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+type keyKind int
+
+const (
+	keyNone   keyKind = iota
+	keyScalar         // key: value
+	keyNested         // key:
+	keyTable          // key{schema}:
+	keyMatrix         // key[RxC]:
+)
+
+// splitKeyLine classifies a line as one of the keyed forms. For scalars rest
+// is the raw value, for tables it is the schema between the braces. Keys
+// containing delimiters are quoted.
+func splitKeyLine(text string) (key, rest string, kind keyKind) {
+	if key, dims, ok := splitMatrixHeader(text); ok {
+		return key, dims, keyMatrix
+	}
+
+	var i int
+	if strings.HasPrefix(text, "\"") {
+		i = quoteEnd(text, 0)
+		if i < 0 || i == len(text) || (text[i] != ':' && text[i] != '{') {
+			return "", "", keyNone
+		}
+		k, err := strconv.Unquote(text[:i])
+		if err != nil {
+			return "", "", keyNone
+		}
+		key = k
+	} else {
+		i = strings.IndexAny(text, "{:")
+		if i < 0 {
+			return "", "", keyNone
+		}
+		key = text[:i]
+	}
+
+	if text[i] == ':' {
+		rest = text[i+1:]
+		if rest == "" {
+			return key, "", keyNested
+		}
+		if rest[0] == ' ' {
+			return key, rest[1:], keyScalar
+		}
+		return "", "", keyNone
+	}
+
+	end := matchingBrace(text, i)
+	if end < 0 || text[end+1:] != ":" {
+		return "", "", keyNone
+	}
+	return key, text[i+1 : end], keyTable
+}
+
+// splitMatrixHeader splits a "key[RxC]:" line into its key and the
+// dimensions between the brackets.
+func splitMatrixHeader(text string) (key, dims string, ok bool) {
+	open := strings.LastIndexByte(text, '[')
+	if open < 0 || !strings.HasSuffix(text, "]:") {
+		return "", "", false
+	}
+	key, dims = text[:open], text[open+1:len(text)-2]
+	if _, _, ok := matrixDims(dims); !ok {
+		return "", "", false
+	}
+	if strings.HasPrefix(key, "\"") {
+		k, err := strconv.Unquote(key)
+		if err != nil {
+			return "", "", false
+		}
+		return k, dims, true
+	}
+	if strings.ContainsAny(key, "{:\"") {
+		return "", "", false
+	}
+	return key, dims, true
+}
+
+// matrixDims parses the "RxC" dimensions of a matrix header. cols is -1
+// for a ragged matrix declared as "Rx*".
+func matrixDims(dims string) (rows, cols int, ok bool) {
+	r, c, found := strings.Cut(dims, "x")
+	if !found {
+		return 0, 0, false
+	}
+	rows, err := strconv.Atoi(strings.TrimSpace(r))
+	if err != nil || rows < 0 {
+		return 0, 0, false
+	}
+	if c = strings.TrimSpace(c); c == "*" {
+		return rows, -1, true
+	}
+	cols, err = strconv.Atoi(c)
+	if err != nil || cols < 0 {
+		return 0, 0, false
+	}
+	return rows, cols, true
+}
+
+// matchingBrace returns the index of the '}' closing the '{' at open,
+// skipping quoted names.
+func matchingBrace(text string, open int) int {
+	depth := 0
+	for i := open; i < len(text); i++ {
+		switch {
+		case opensQuote(text, i, schemaDelims):
+			if end := quoteEnd(text, i); end > 0 {
+				i = end - 1
+			}
+		case text[i] == '{':
+			depth++
+		case text[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isKeyLine(text string) bool {
+	_, _, kind := splitKeyLine(text)
+	return kind != keyNone
+}
+
+func isMarker(text string) bool {
+	return strings.HasPrefix(text, "> ")
+}
+
+func isListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitCells splits a row on the pipes that are not inside quoted cells.
+func splitCells(text string) []cell {
+	parts := splitRow(text)
+	cells := make([]cell, len(parts))
+	pos := 0
+	for i, part := range parts {
+		cells[i] = cell{text: part, pos: pos}
+		pos += len(part) + 1
+	}
+	return cells
+}
+
+// fitCells pads cells with empty values or truncates them to width.
+func fitCells(cells []cell, width, end int) []cell {
+	for len(cells) < width {
+		cells = append(cells, cell{pos: end})
+	}
+	return cells[:width]
+}
+
+func columnNames(columns []column) string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// cellOffset returns the position where cell n starts, or the end of text
+// when the row has fewer cells. It points at the first surplus cell of a
+// row that is too wide and at the end of a row that is too narrow.
+func cellOffset(text string, cells []cell, n int) int {
+	if n < len(cells) {
+		return cells[n].pos
+	}
+	return len(text)
+}
+
+func findColumn(columns []column, name string) (column, bool) {
+	for _, col := range columns {
+		if col.name == name {
+			return col, true
+		}
+	}
+	return column{}, false
+}
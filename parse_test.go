@@ -0,0 +1,280 @@
+package jet
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxError(t *testing.T) {
+	type Product struct {
+		ID   int
+		Name string
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		line   int
+		column int
+		msg    string
+	}{
+		{
+			name:   "too many cells",
+			input:  "products{id|name}:\n 1|Laptop\n 2|Mouse|extra\n",
+			line:   3,
+			column: 10,
+			msg:    "expected 2 cells, got 3",
+		},
+		{
+			name:   "too few cells",
+			input:  "products{id|name}:\n 1\n",
+			line:   2,
+			column: 3,
+			msg:    "expected 2 cells, got 1",
+		},
+		{
+			name:   "unknown nested column",
+			input:  "products{id|name}:\n 1|Laptop\n   > price:\n  amount: 3\n",
+			line:   3,
+			column: 6,
+			msg:    "expected one of the columns id, name, got \"price\"",
+		},
+		{
+			name:   "bad indentation",
+			input:  "config:\n route: /home\n   method: GET\n",
+			line:   3,
+			column: 4,
+			msg:    "expected indentation of 1 spaces, got 3",
+		},
+		{
+			name:   "not a key",
+			input:  "name: Laptop\njust some prose\n",
+			line:   2,
+			column: 1,
+			msg:    "expected \"key: value\", \"key:\" or \"key{...}:\", got \"just some prose\"",
+		},
+		{
+			name:   "malformed first key",
+			input:  "name:Laptop\nid: 30\n",
+			line:   1,
+			column: 1,
+			msg:    "expected \"key: value\", \"key:\" or \"key{...}:\", got \"name:Laptop\"",
+		},
+		{
+			name:   "unclosed header",
+			input:  "products{id|name:\n",
+			line:   1,
+			column: 1,
+			msg:    "expected \"key: value\", \"key:\" or \"key{...}:\", got \"products{id|name:\"",
+		},
+		{
+			name:   "empty column name",
+			input:  "products{id||name}:\n 1||Laptop\n",
+			line:   1,
+			column: 13,
+			msg:    "expected column name, got \"\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result struct {
+				Products []Product
+			}
+			err := Unmarshal([]byte(tt.input), &result)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(fmt.Errorf("wrapped: %w", err), &syntaxErr) {
+				t.Fatalf("Expected *SyntaxError, got %v", err)
+			}
+			t.Logf("Error: %v", syntaxErr)
+
+			if syntaxErr.Line != tt.line || syntaxErr.Column != tt.column {
+				t.Errorf("Expected line %d, column %d, got line %d, column %d", tt.line, tt.column, syntaxErr.Line, syntaxErr.Column)
+			}
+			if syntaxErr.Msg != tt.msg {
+				t.Errorf("Expected message %q, got %q", tt.msg, syntaxErr.Msg)
+			}
+
+			lines := strings.Split(tt.input, "\n")
+			if syntaxErr.Text != lines[tt.line-1] {
+				t.Errorf("Expected offending line %q, got %q", lines[tt.line-1], syntaxErr.Text)
+			}
+
+			lineStart := len(strings.Join(lines[:tt.line-1], "\n")) + 1
+			if tt.line == 1 {
+				lineStart = 0
+			}
+			if want := int64(lineStart + tt.column - 1); syntaxErr.Offset != want {
+				t.Errorf("Expected offset %d, got %d", want, syntaxErr.Offset)
+			}
+		})
+	}
+}
+
+func TestUnmarshalLenient(t *testing.T) {
+	type Product struct {
+		ID       int
+		Name     string
+		Category string
+	}
+
+	input := "Sure! Here are the products you asked for:\n" +
+		"```jet\n" +
+		"products{id|name|category}:\n" +
+		"  1|Laptop|Electronics\n" +
+		"  2|Mouse\n" +
+		"    3|Book|Literature|extra\n" +
+		"count: 3\n" +
+		"this line is not jet\n" +
+		"```\n" +
+		"Let me know if you need anything else.\n"
+
+	var result struct {
+		Products []Product
+		Count    int
+	}
+
+	if err := Unmarshal([]byte(input), &result); err == nil {
+		t.Fatalf("Expected strict Unmarshal to fail")
+	}
+
+	diagnostics, err := UnmarshalWithOptions([]byte(input), &result, DecodeOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("UnmarshalWithOptions failed: %v", err)
+	}
+	for _, d := range diagnostics {
+		t.Logf("Diagnostic: %v", d)
+	}
+
+	expected := []Product{
+		{ID: 1, Name: "Laptop", Category: "Electronics"},
+		{ID: 2, Name: "Mouse"},
+		{ID: 3, Name: "Book", Category: "Literature"},
+	}
+	if !reflect.DeepEqual(result.Products, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result.Products)
+	}
+	if result.Count != 3 {
+		t.Errorf("Expected count 3, got %d", result.Count)
+	}
+
+	expectedLines := []int{1, 2, 5, 6, 6, 8, 9, 10}
+	if len(diagnostics) != len(expectedLines) {
+		t.Fatalf("Expected %d diagnostics, got %d", len(expectedLines), len(diagnostics))
+	}
+	for i, d := range diagnostics {
+		if d.Line != expectedLines[i] {
+			t.Errorf("Diagnostic %d: expected line %d, got %d (%s)", i, expectedLines[i], d.Line, d.Msg)
+		}
+	}
+}
+
+func TestUnmarshalLenientUnindentedRows(t *testing.T) {
+	var rows []map[string]interface{}
+	diagnostics, err := UnmarshalWithOptions([]byte("{id|name}:\n1|Laptop\n2|Mouse\n"), &rows, DecodeOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("UnmarshalWithOptions failed: %v", err)
+	}
+	if len(rows) != 2 || rows[1]["name"] != "Mouse" {
+		t.Errorf("Expected two rows, got %v", rows)
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("Expected 2 diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestUnmarshalLenientFirstLine(t *testing.T) {
+	type Person struct {
+		Name string
+		Age  int
+		City string
+	}
+
+	for name, input := range map[string]string{
+		"malformed key":   "name:Alice\nage: 30\ncity: Paris\n",
+		"drifting indent": "  name:Alice\nage: 30\ncity: Paris\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var result Person
+			diagnostics, err := UnmarshalWithOptions([]byte(input), &result, DecodeOptions{Lenient: true})
+			if err != nil {
+				t.Fatalf("UnmarshalWithOptions failed: %v", err)
+			}
+			if result.Age != 30 || result.City != "Paris" {
+				t.Errorf("Expected the lines after the first to be decoded, got %+v", result)
+			}
+			if len(diagnostics) == 0 || diagnostics[0].Line != 1 {
+				t.Errorf("Expected a diagnostic for line 1, got %v", diagnostics)
+			}
+		})
+	}
+
+	var result Person
+	diagnostics, err := UnmarshalWithOptions([]byte("  name: Alice\nage: 30\n"), &result, DecodeOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("UnmarshalWithOptions failed: %v", err)
+	}
+	if result.Name != "Alice" || result.Age != 30 || len(diagnostics) != 1 {
+		t.Errorf("Expected both keys and one diagnostic, got %+v and %v", result, diagnostics)
+	}
+}
+
+func TestUnmarshalLenientSingleColumn(t *testing.T) {
+	var result struct {
+		Tags []struct{ Name string }
+		Note string
+	}
+	diagnostics, err := UnmarshalWithOptions([]byte("tags{name}:\nfoo\nbar\nnote: hi\n"), &result, DecodeOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("UnmarshalWithOptions failed: %v", err)
+	}
+	if len(result.Tags) != 2 || result.Tags[0].Name != "foo" || result.Tags[1].Name != "bar" || result.Note != "hi" {
+		t.Errorf("Expected both unindented rows and the note, got %+v", result)
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("Expected an indentation diagnostic per row, got %v", diagnostics)
+	}
+}
+
+func TestUnmarshalLenientTrailingProse(t *testing.T) {
+	type User struct {
+		ID   int
+		Name string
+		Tags []string
+	}
+	table := "users{id|name|tags}:\n  1|Alice|[a,b]\n  2|Bob|[c]\n"
+	prose := "Let me know if you need anything else.\n"
+
+	for name, input := range map[string]string{
+		"bare":   table + prose,
+		"fenced": "```jet\n" + table + prose + "```\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var result struct{ Users []User }
+			diagnostics, err := UnmarshalWithOptions([]byte(input), &result, DecodeOptions{Lenient: true})
+			if err != nil {
+				t.Fatalf("UnmarshalWithOptions failed: %v", err)
+			}
+			expected := []User{{ID: 1, Name: "Alice", Tags: []string{"a", "b"}}, {ID: 2, Name: "Bob", Tags: []string{"c"}}}
+			if !reflect.DeepEqual(result.Users, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, result.Users)
+			}
+			prose := 0
+			for _, d := range diagnostics {
+				if strings.Contains(d.Msg, "Let me know") {
+					prose++
+				}
+				if strings.Contains(d.Msg, "indentation") {
+					t.Errorf("Unexpected diagnostic: %v", d)
+				}
+			}
+			if prose != 1 {
+				t.Errorf("Expected one diagnostic for the prose, got %v", diagnostics)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package jet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+	"strings"
+)
+
+// An Encoder writes Jet documents to an output stream. Values passed to
+// Encode are written one after the other, so objects and named tables
+// written in turn make up a single document:
+//
+//	enc := jet.NewEncoder(w)
+//	enc.Encode(map[string]interface{}{"exported": time.Now()})
+//	enc.BeginTable("users", []string{"id", "name"})
+//	for rows.Next() {
+//		rows.Scan(&id, &name)
+//		enc.WriteRow(id, name)
+//	}
+//	enc.EndTable()
+//
+// The table methods encode each row as it is given and only buffer the
+// output, so a table never has to be held in memory.
+type Encoder struct {
+	w    *bufio.Writer
+	opts EncodeOptions
+
+	columns []string // columns of the open table
+	open    bool     // a table was begun and not ended
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// SetOptions sets the options used by later calls to Encode and WriteRow.
+func (enc *Encoder) SetOptions(opts EncodeOptions) {
+	enc.opts = opts
+}
+
+// Encode writes the Jet encoding of v to the stream, as Marshal would with
+// the encoder's options.
+func (enc *Encoder) Encode(v interface{}) error {
+	if enc.open {
+		return errors.New("jet: Encode called before EndTable")
+	}
+	e := &encodeState{opts: enc.opts, visiting: map[visit]int{}}
+	data, err := e.encode(v)
+	if err != nil {
+		return err
+	}
+	w := &jetWriter{sb: enc.w, opts: enc.opts}
+	if err := w.writeValue(data, 0); err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
+
+// BeginTable writes the header of a table with the given columns. An empty
+// name starts a document that is a table itself. Rows are then written with
+// WriteRow and the table is closed with EndTable.
+func (enc *Encoder) BeginTable(name string, columns []string) error {
+	if enc.open {
+		return errors.New("jet: BeginTable called before EndTable")
+	}
+	header := "{" + strings.Join(formatKeys(columns), "|") + "}:\n"
+	if name != "" {
+		header = formatKey(name) + header
+	}
+	if _, err := enc.w.WriteString(header); err != nil {
+		return err
+	}
+	enc.columns = append([]string(nil), columns...)
+	enc.open = true
+	return nil
+}
+
+// WriteRow writes a row of the open table, with one value per column in the
+// order given to BeginTable. Values are encoded as by Encode and must be
+// scalars or lists of scalars. Rows are buffered and written out as the
+// buffer fills and by EndTable.
+func (enc *Encoder) WriteRow(values ...interface{}) error {
+	if !enc.open {
+		return errors.New("jet: WriteRow called without BeginTable")
+	}
+	if len(values) != len(enc.columns) {
+		return fmt.Errorf("jet: WriteRow got %d values for %d columns", len(values), len(enc.columns))
+	}
+
+	cells := make([]string, len(values))
+	for i, v := range values {
+		e := &encodeState{opts: enc.opts, visiting: map[visit]int{}, depth: 1}
+		data, err := e.encode(v)
+		if err != nil {
+			return err
+		}
+		if isBlock(data) {
+			return fmt.Errorf("jet: WriteRow value for column %q is not a scalar", enc.columns[i])
+		}
+		cells[i] = formatScalar(data)
+	}
+	_, err := enc.w.WriteString(" " + strings.Join(cells, "|") + "\n")
+	return err
+}
+
+// EndTable closes the open table and flushes the rows written since the
+// last flush.
+func (enc *Encoder) EndTable() error {
+	if !enc.open {
+		return errors.New("jet: EndTable called without BeginTable")
+	}
+	enc.columns, enc.open = nil, false
+	return enc.w.Flush()
+}
+
+// A Decoder reads a Jet document from an input stream one top-level entry
+// at a time, so that a large table can be decoded row by row:
+//
+//	dec := jet.NewDecoder(r)
+//	for {
+//		tok, err := dec.Token()
+//		if err == io.EOF {
+//			break
+//		}
+//		if table, ok := tok.(jet.Table); ok && table.Name == "users" {
+//			for user, err := range jet.Rows[User](dec) {
+//				...
+//			}
+//		}
+//	}
+//
+// Only the lines of the current entry or row are held in memory.
+type Decoder struct {
+	r      *bufio.Reader
+	num    int   // number of lines read
+	offset int64 // byte offset of the next line
+	peeked *line
+	err    error // error that ended reading, io.EOF at the end of the input
+
+	base  int      // indentation of the top-level entries, -1 until known
+	key   string   // key of the last Key token
+	value []line   // lines of the entry of the last Key token until decoded
+	table []column // columns of the table of the last Table token
+}
+
+// A Token is a top-level entry of a document returned by Decoder.Token: a
+// Key or a Table.
+type Token interface{}
+
+// Key is the key of a top-level entry that is not a table. Its value is
+// read by Decoder.Decode, or skipped by the next call to Token.
+type Key string
+
+// Table is the header of a top-level table. Its rows are read one at a time
+// by Decoder.DecodeRow or Rows, and the rows left are skipped by the next
+// call to Token.
+type Table struct {
+	Name    string // empty for a document that is a table
+	Columns []string
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), base: -1}
+}
+
+// Token returns the next top-level entry of the document, or io.EOF at the
+// end of the input. Documents that are a list or a single scalar have no
+// entries and are read with Decode.
+func (dec *Decoder) Token() (Token, error) {
+	if err := dec.skip(); err != nil {
+		return nil, err
+	}
+	l, ok, err := dec.peek()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, io.EOF
+	}
+	if dec.base < 0 {
+		dec.base = l.indent
+	}
+	p := &parser{}
+	if l.indent != dec.base {
+		return nil, p.errorf(l, 0, "expected indentation of %d spaces, got %d", dec.base, l.indent)
+	}
+
+	key, rest, kind := splitKeyLine(l.text)
+	switch kind {
+	case keyNone:
+		return nil, p.errorf(l, 0, "expected \"key: value\", \"key:\" or \"key{...}:\", got %q", l.text)
+	case keyTable:
+		columns, err := p.parseSchema(rest, l)
+		if err != nil {
+			return nil, err
+		}
+		dec.peeked = nil
+		dec.table = columns
+		names := make([]string, len(columns))
+		for i, col := range columns {
+			names[i] = col.name
+		}
+		return Table{Name: key, Columns: names}, nil
+	}
+
+	lines, err := dec.block(dec.base)
+	if err != nil {
+		return nil, err
+	}
+	dec.key, dec.value = key, lines
+	return Key(key), nil
+}
+
+// More reports whether the current table has another row or, outside a
+// table, whether the document has another entry.
+func (dec *Decoder) More() bool {
+	l, ok, err := dec.peek()
+	if err != nil || !ok {
+		return false
+	}
+	return dec.table == nil || l.indent > dec.base
+}
+
+// Decode stores the value of the entry of the last Key token in the value
+// pointed to by v. Called before any token, or once the entries of interest
+// have been read, it decodes the rest of the document as Unmarshal would.
+func (dec *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	if dec.table != nil {
+		return errors.New("jet: Decode called inside a table; use DecodeRow")
+	}
+
+	var node interface{}
+	if dec.value != nil {
+		p := &parser{lines: dec.value}
+		entry, err := p.parseMap(dec.base)
+		if err != nil {
+			return err
+		}
+		node = entry[dec.key]
+		dec.value = nil
+	} else {
+		lines, err := dec.block(-1)
+		if err != nil {
+			return err
+		}
+		p := &parser{lines: lines}
+		if node, err = p.parseDocument(); err != nil {
+			return err
+		}
+	}
+	return decodeNode(node, rv.Elem())
+}
+
+// DecodeRow stores the next row of the table of the last Table token in
+// the value pointed to by v, which is typically a struct or a map. It
+// returns io.EOF once the table has no more rows.
+func (dec *Decoder) DecodeRow(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	if dec.table == nil {
+		return errors.New("jet: DecodeRow called outside a table")
+	}
+	l, ok, err := dec.peek()
+	if err != nil {
+		return err
+	}
+	if !ok || l.indent <= dec.base {
+		return io.EOF
+	}
+
+	// A row spans its own line and the more indented nested blocks below.
+	lines, err := dec.block(l.indent)
+	if err != nil {
+		return err
+	}
+	p := &parser{lines: lines, pos: 1}
+	row, err := p.parseRow(dec.table, lines[0])
+	if err != nil {
+		return err
+	}
+	if p.pos < len(lines) {
+		next := lines[p.pos]
+		return p.errorf(next, 0, "expected row indentation of %d spaces, got %d", l.indent, next.indent)
+	}
+	return decodeNode(row, rv.Elem())
+}
+
+// Rows returns an iterator over the remaining rows of the table of the last
+// Table token, decoding each into a T. It stops after yielding the first
+// error.
+func Rows[T any](dec *Decoder) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			var row T
+			err := dec.DecodeRow(&row)
+			if err == io.EOF {
+				return
+			}
+			if !yield(row, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// skip discards the value or table rows left unread by the last token.
+func (dec *Decoder) skip() error {
+	for dec.table != nil {
+		l, ok, err := dec.peek()
+		if err != nil {
+			return err
+		}
+		if !ok || l.indent <= dec.base {
+			dec.table = nil
+			break
+		}
+		dec.peeked = nil
+	}
+	dec.value = nil
+	return nil
+}
+
+// block consumes the next line and returns it with the following lines
+// that are indented deeper than indent.
+func (dec *Decoder) block(indent int) ([]line, error) {
+	var lines []line
+	for {
+		l, ok, err := dec.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || (l.indent <= indent && len(lines) > 0) {
+			return lines, nil
+		}
+		lines = append(lines, l)
+		dec.peeked = nil
+	}
+}
+
+// peek returns the next line that is not completely empty without
+// consuming it, and false at the end of the input.
+func (dec *Decoder) peek() (line, bool, error) {
+	for dec.peeked == nil {
+		if dec.err != nil {
+			if dec.err == io.EOF {
+				return line{}, false, nil
+			}
+			return line{}, false, dec.err
+		}
+		raw, err := dec.r.ReadString('\n')
+		if err != nil {
+			dec.err = err
+			if raw == "" {
+				continue
+			}
+		}
+		dec.num++
+		l := line{num: dec.num, offset: dec.offset}
+		dec.offset += int64(len(raw))
+		raw = strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+		text := strings.TrimLeft(raw, " ")
+		l.indent, l.text = len(raw)-len(text), text
+		if l.indent == 0 && l.text == "" {
+			continue
+		}
+		dec.peeked = &l
+	}
+	return *dec.peeked, true, nil
+}
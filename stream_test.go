@@ -0,0 +1,265 @@
+package jet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	type user struct {
+		ID   int    `jet:"id"`
+		Name string `jet:"name"`
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(map[string]interface{}{"source": "db", "version": 2}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if err := enc.BeginTable("users", []string{"id", "name", "tags"}); err != nil {
+		t.Fatalf("BeginTable failed: %v", err)
+	}
+	rows := [][]interface{}{{1, "Alice", []string{"admin"}}, {2, "a|b", nil}}
+	for _, row := range rows {
+		if err := enc.WriteRow(row...); err != nil {
+			t.Fatalf("WriteRow failed: %v", err)
+		}
+	}
+	if err := enc.EndTable(); err != nil {
+		t.Fatalf("EndTable failed: %v", err)
+	}
+	t.Logf("Encoder output:\n%s", buf.String())
+
+	expected := "source: db\nversion: 2\nusers{id|name|tags}:\n 1|Alice|[admin]\n 2|\"a|b\"|~\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	var got struct {
+		Source string `jet:"source"`
+		Users  []user `jet:"users"`
+	}
+	if err := Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(got.Users, []user{{1, "Alice"}, {2, "a|b"}}) || got.Source != "db" {
+		t.Errorf("Unexpected round trip: %+v", got)
+	}
+}
+
+func TestEncoderMatchesMarshal(t *testing.T) {
+	value := []map[string]interface{}{
+		{"id": 1, "profile": map[string]string{"email": "a@x"}},
+		{"id": 2, "profile": map[string]string{"email": "b@x"}},
+	}
+	opts := EncodeOptions{Format: FormatNormalized, KeyOrder: DeclarationOrder}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetOptions(opts)
+	if err := enc.Encode(value); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	expected, err := MarshalWithOptions(value, opts)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if buf.String() != string(expected) {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestEncoderTableRows(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.WriteRow(1); err == nil {
+		t.Errorf("Expected error for WriteRow without BeginTable")
+	}
+	if err := enc.BeginTable("", []string{"id", "meta"}); err != nil {
+		t.Fatalf("BeginTable failed: %v", err)
+	}
+	if err := enc.WriteRow(1); err == nil || !strings.Contains(err.Error(), "1 values for 2 columns") {
+		t.Errorf("Expected column count error, got %v", err)
+	}
+	if err := enc.WriteRow(1, map[string]int{"a": 1}); err == nil {
+		t.Errorf("Expected error for a nested value")
+	}
+	if err := enc.Encode(1); err == nil {
+		t.Errorf("Expected error for Encode inside a table")
+	}
+	if err := enc.WriteRow(1, "x"); err != nil {
+		t.Fatalf("WriteRow failed: %v", err)
+	}
+	if err := enc.EndTable(); err != nil {
+		t.Fatalf("EndTable failed: %v", err)
+	}
+	if buf.String() != "{id|meta}:\n 1|x\n" {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestEncoderWriteError(t *testing.T) {
+	enc := NewEncoder(failingWriter{})
+	if err := enc.Encode(map[string]int{"a": 1}); err == nil || err.Error() != "disk full" {
+		t.Errorf("Expected write error, got %v", err)
+	}
+}
+
+func TestDecoderTokens(t *testing.T) {
+	input := `source: db
+meta:
+ version: 2
+users{id|name|profile}:
+ 1|Alice
+   > profile:
+  email: a@x
+ 2|Bob
+   > profile:
+  email: b@x
+tags: [a,b]
+`
+	type profile struct {
+		Email string `jet:"email"`
+	}
+	type user struct {
+		ID      int     `jet:"id"`
+		Name    string  `jet:"name"`
+		Profile profile `jet:"profile"`
+	}
+
+	dec := NewDecoder(strings.NewReader(input))
+	var (
+		keys  []string
+		users []user
+		tags  []string
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token failed: %v", err)
+		}
+		switch tok := tok.(type) {
+		case Key:
+			keys = append(keys, string(tok))
+			if tok == "tags" {
+				if err := dec.Decode(&tags); err != nil {
+					t.Fatalf("Decode failed: %v", err)
+				}
+			}
+		case Table:
+			if tok.Name != "users" || !reflect.DeepEqual(tok.Columns, []string{"id", "name", "profile"}) {
+				t.Errorf("Unexpected table %+v", tok)
+			}
+			for dec.More() {
+				var u user
+				if err := dec.DecodeRow(&u); err != nil {
+					t.Fatalf("DecodeRow failed: %v", err)
+				}
+				users = append(users, u)
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(keys, []string{"source", "meta", "tags"}) {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	expected := []user{{1, "Alice", profile{"a@x"}}, {2, "Bob", profile{"b@x"}}}
+	if !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, users)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+}
+
+func TestDecoderRows(t *testing.T) {
+	type item struct {
+		SKU string `jet:"sku"`
+		Qty int    `jet:"qty"`
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.BeginTable("", []string{"sku", "qty"})
+	for i := 0; i < 1000; i++ {
+		enc.WriteRow(fmt.Sprintf("S%d", i), i)
+	}
+	enc.EndTable()
+
+	dec := NewDecoder(&buf)
+	tok, err := dec.Token()
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if table, ok := tok.(Table); !ok || table.Name != "" {
+		t.Fatalf("Expected unnamed table, got %#v", tok)
+	}
+	n := 0
+	for row, err := range Rows[item](dec) {
+		if err != nil {
+			t.Fatalf("Row %d failed: %v", n, err)
+		}
+		if row != (item{fmt.Sprintf("S%d", n), n}) {
+			t.Fatalf("Unexpected row %d: %+v", n, row)
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Errorf("Expected 1000 rows, got %d", n)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the table, got %v", err)
+	}
+}
+
+func TestDecoderSkipsAndErrors(t *testing.T) {
+	input := "skipped{a}:\n 1\n 2\nnested:\n deep: 1\nlast: 3\n"
+	dec := NewDecoder(strings.NewReader(input))
+	var tokens []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Token failed: %v", err)
+		}
+		tokens = append(tokens, tok)
+	}
+	expected := []Token{Table{Name: "skipped", Columns: []string{"a"}}, Key("nested"), Key("last")}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, tokens)
+	}
+
+	// Decode without tokens reads the whole document
+	var all map[string]interface{}
+	if err := NewDecoder(strings.NewReader(input)).Decode(&all); err != nil || all["last"] != 3 {
+		t.Errorf("Unexpected Decode result %v, %v", all, err)
+	}
+
+	dec = NewDecoder(strings.NewReader("t{a|b}:\n 1|2\n 1|2|3\n"))
+	dec.Token()
+	var row map[string]interface{}
+	if err := dec.DecodeRow(&row); err != nil {
+		t.Fatalf("DecodeRow failed: %v", err)
+	}
+	err := dec.DecodeRow(&row)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Line != 3 {
+		t.Errorf("Expected *SyntaxError on line 3, got %v", err)
+	}
+	if err := dec.Decode(&row); err == nil {
+		t.Errorf("Expected error for Decode inside a table")
+	}
+}
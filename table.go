@@ -0,0 +1,227 @@
+package jet
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// structTable is stored in place of a non-empty slice or array of structs
+// whose fields are all written as single cells. The writer prints its rows
+// straight from the reflect values, without building an object per row.
+type structTable struct {
+	rows reflect.Value
+	enc  *structEncoder
+}
+
+// structEncoder holds what is needed to write a struct type as a table row.
+// It is computed once per type.
+type structEncoder struct {
+	cells []cellEncoder // nil when a field is not written as a scalar
+}
+
+// cellEncoder writes one struct field as a table cell.
+type cellEncoder struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	write     func(b []byte, v reflect.Value) []byte
+}
+
+var structEncoderCache sync.Map // map[reflect.Type]*structEncoder
+
+func cachedStructEncoder(t reflect.Type) *structEncoder {
+	if enc, ok := structEncoderCache.Load(t); ok {
+		return enc.(*structEncoder)
+	}
+	enc, _ := structEncoderCache.LoadOrStore(t, newStructEncoder(t))
+	return enc.(*structEncoder)
+}
+
+// newStructEncoder builds the cell encoders of struct type t. Types that
+// encode themselves, and fields that are not scalars or time.Time, reached
+// through embedded pointers or inline maps, leave the type to the generic
+// encoder.
+func newStructEncoder(t reflect.Type) *structEncoder {
+	if implementsAny(t, marshalerType, textMarshalerType) {
+		return &structEncoder{}
+	}
+	fields := cachedTypeFields(t)
+	cells := make([]cellEncoder, 0, len(fields))
+	for _, f := range fields {
+		if throughPointer(t, f.index) {
+			return &structEncoder{}
+		}
+		write := cellWriter(f.typ, f.opts)
+		if write == nil {
+			return &structEncoder{}
+		}
+		cells = append(cells, cellEncoder{name: f.name, index: f.index, omitEmpty: f.opts.Contains("omitempty"), write: write})
+	}
+	return &structEncoder{cells: cells}
+}
+
+func implementsAny(t reflect.Type, ifaces ...reflect.Type) bool {
+	for _, iface := range ifaces {
+		if t.Implements(iface) || reflect.PointerTo(t).Implements(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// throughPointer reports whether the field of t at index is promoted
+// through an embedded pointer, which may be nil.
+func throughPointer(t reflect.Type, index []int) bool {
+	for _, x := range index[:len(index)-1] {
+		t = t.Field(x).Type
+		if t.Kind() == reflect.Ptr {
+			return true
+		}
+	}
+	return false
+}
+
+// cellWriter returns the function appending the cell of a field of type t
+// with the given tag options, matching what encode and formatScalar would
+// write, or nil when the field is not a scalar written by its kind.
+func cellWriter(t reflect.Type, opts tagOptions) func(b []byte, v reflect.Value) []byte {
+	if t == timeType {
+		// The string option does not apply to times, as in encodeField.
+		format := opts.Get("format")
+		return func(b []byte, v reflect.Value) []byte {
+			tm := v.Interface().(time.Time)
+			if format == "" {
+				return append(b, formatScalar(tm.Format(time.RFC3339Nano))...)
+			}
+			return append(b, formatScalar(formatTime(tm, format))...)
+		}
+	}
+	// Types that encode themselves, including time.Duration, are left to
+	// encode. Other named scalars, such as enums, are written by kind.
+	if t == durationType || implementsAny(t, marshalerType, textMarshalerType) {
+		return nil
+	}
+
+	var write func(b []byte, v reflect.Value) []byte
+	switch t.Kind() {
+	case reflect.String:
+		return func(b []byte, v reflect.Value) []byte {
+			s := v.String()
+			if needsQuoting(s) || isAmbiguous(s) {
+				return strconv.AppendQuote(b, s)
+			}
+			return append(b, s...)
+		}
+	case reflect.Bool:
+		write = func(b []byte, v reflect.Value) []byte {
+			return strconv.AppendBool(b, v.Bool())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		write = func(b []byte, v reflect.Value) []byte {
+			return strconv.AppendInt(b, v.Int(), 10)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		write = func(b []byte, v reflect.Value) []byte {
+			return strconv.AppendUint(b, v.Uint(), 10)
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		write = func(b []byte, v reflect.Value) []byte {
+			return strconv.AppendFloat(b, v.Float(), 'g', -1, bits)
+		}
+	default:
+		return nil
+	}
+	if opts.Contains("string") {
+		// The value is written as a string, quoted when it reads as a number.
+		plain := write
+		write = func(b []byte, v reflect.Value) []byte {
+			start := len(b)
+			b = plain(b, v)
+			if s := string(b[start:]); isAmbiguous(s) {
+				return strconv.AppendQuote(b[:start], s)
+			}
+			return b
+		}
+	}
+	return write
+}
+
+// structTable returns the direct-write form of the slice or array val, or
+// nil when it has to go through the generic encoder.
+func (e *encodeState) structTable(val reflect.Value) *structTable {
+	if val.Len() == 0 || val.Type().Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	if e.opts.MaxDepth > 0 && e.depth+1 >= e.opts.MaxDepth {
+		return nil // The rows are elided
+	}
+	enc := cachedStructEncoder(val.Type().Elem())
+	if enc.cells == nil {
+		return nil
+	}
+	return &structTable{rows: val, enc: enc}
+}
+
+// writeStructTable writes t as writeTabularArray would write its rows as
+// objects. All three formats agree on tables without nested values.
+func (w *jetWriter) writeStructTable(indentStr, key string, t *structTable, indentLevel int) {
+	cells := t.enc.cells
+	names := make([]string, len(cells))
+	byName := make(map[string]*cellEncoder, len(cells))
+	for i := range cells {
+		names[i] = cells[i].name
+		byName[cells[i].name] = &cells[i]
+	}
+
+	schema := make([]*cellEncoder, 0, len(cells))
+	for _, name := range w.orderKeys(&object{keys: names}) {
+		if c := byName[name]; !c.omitEmpty || !t.emptyInAllRows(c) {
+			schema = append(schema, c)
+		}
+	}
+
+	var header strings.Builder
+	header.WriteString(indentStr + key + "{")
+	for i, c := range schema {
+		if i > 0 {
+			header.WriteByte('|')
+		}
+		header.WriteString(formatKey(c.name))
+	}
+	header.WriteString("}:\n")
+	w.sb.WriteString(header.String())
+
+	rowIndent := strings.Repeat(" ", indentLevel+1)
+	var line []byte
+	for i := 0; i < t.rows.Len(); i++ {
+		row := t.rows.Index(i)
+		line = append(line[:0], rowIndent...)
+		for j, c := range schema {
+			if j > 0 {
+				line = append(line, '|')
+			}
+			v := row.FieldByIndex(c.index)
+			if c.omitEmpty && isEmptyValue(v) {
+				continue // Omitted
+			}
+			line = c.write(line, v)
+		}
+		line = append(line, '\n')
+		w.sb.Write(line)
+	}
+}
+
+// emptyInAllRows reports whether the field of c is empty in every row, in
+// which case its omitempty column is left out of the table.
+func (t *structTable) emptyInAllRows(c *cellEncoder) bool {
+	for i := 0; i < t.rows.Len(); i++ {
+		if !isEmptyValue(t.rows.Index(i).FieldByIndex(c.index)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package jet
+
+import "strings"
+
+// tagOptions is the comma-separated list of options following the name in
+// a jet struct tag, e.g. `jet:"id,omitempty,format=hex"`. The options are:
+//
+//   - omitempty: leave the field out when it holds a zero value, and drop
+//     its table column when it is empty in every row;
+//   - string: write numbers and bools as quoted strings;
+//   - inline: promote the fields of a struct, or the entries of a map with
+//     string keys, into the parent object;
+//   - format=hex|base64 for byte slices and arrays, and format=rfc3339,
+//     date, datetime, time, unix, unixmilli or a Go time layout for
+//     time.Time values;
+//   - hex: shorthand for format=hex.
+type tagOptions string
+
+// parseTag splits a jet struct tag into its name and options.
+func parseTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+// Contains reports whether the comma-separated options contain name.
+func (o tagOptions) Contains(name string) bool {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of a "name=value" option, or "" when absent.
+func (o tagOptions) Get(name string) string {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		if value, ok := strings.CutPrefix(opt, name+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
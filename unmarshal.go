@@ -0,0 +1,543 @@
+package jet
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
+// The argument to Unmarshal must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "jet: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "jet: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "jet: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// An UnmarshalTypeError describes a Jet value that was not appropriate for a
+// value of a specific Go type.
+type UnmarshalTypeError struct {
+	Value string       // description of the Jet value, e.g. "object", "scalar abc"
+	Type  reflect.Type // type of Go value it could not be assigned to
+	Field string       // dotted path of the field holding the value, if any
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return "jet: cannot unmarshal " + e.Value + " into Go struct field " + e.Field + " of type " + e.Type.String()
+	}
+	return "jet: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+}
+
+// A LossyFieldError reports a field that MarshalFlattened replaced with a
+// "[nested]" or "[table]" placeholder because it could not be written
+// inline, or that EncodeOptions.MaxDepth replaced with the "..." elision
+// marker. The rest of the document is still decoded.
+type LossyFieldError struct {
+	Field       string // dotted path of the lost field
+	Placeholder string // "[nested]", "[table]" or "..."
+}
+
+func (e *LossyFieldError) Error() string {
+	return "jet: field " + e.Field + " was written as " + e.Placeholder + " and cannot be restored"
+}
+
+// placeholder is a cell MarshalFlattened, or a value MaxDepth, wrote in
+// place of a value.
+type placeholder string
+
+// decodeState holds the state of a single Unmarshal call.
+type decodeState struct {
+	path       []string   // keys leading to the value being decoded
+	opts       tagOptions // tag options of the struct field being decoded
+	savedError error
+}
+
+func unmarshal(data []byte, v interface{}, opts DecodeOptions) ([]*SyntaxError, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+
+	node, diagnostics, err := parse(data, opts.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	return diagnostics, decodeNode(node, rv.Elem())
+}
+
+// decodeNode stores a parsed document into rv, returning the first error
+// that did not stop decoding once the rest has been decoded.
+func decodeNode(node interface{}, rv reflect.Value) error {
+	d := &decodeState{}
+	if err := d.decode(node, rv); err != nil {
+		return err
+	}
+	return d.savedError
+}
+
+// saveError records the first error that does not stop decoding.
+func (d *decodeState) saveError(err error) {
+	if d.savedError == nil {
+		d.savedError = err
+	}
+}
+
+func (d *decodeState) field() string {
+	return strings.Join(d.path, ".")
+}
+
+func (d *decodeState) typeError(value string, t reflect.Type) error {
+	return &UnmarshalTypeError{Value: value, Type: t, Field: d.field()}
+}
+
+// decodeChild decodes a value found under key, keeping track of the path.
+func (d *decodeState) decodeChild(key string, node interface{}, rv reflect.Value) error {
+	return d.decodeField(key, "", node, rv)
+}
+
+// decodeField is like decodeChild for a struct field, whose tag options
+// apply to its own value but not to the values nested in it.
+func (d *decodeState) decodeField(key string, opts tagOptions, node interface{}, rv reflect.Value) error {
+	d.path = append(d.path, key)
+	saved := d.opts
+	d.opts = opts
+	err := d.decode(node, rv)
+	d.opts = saved
+	d.path = d.path[:len(d.path)-1]
+	return err
+}
+
+// decode stores a node of the generic tree produced by parse into rv.
+func (d *decodeState) decode(node interface{}, rv reflect.Value) error {
+	if p, ok := node.(placeholder); ok {
+		d.saveError(&LossyFieldError{Field: d.field(), Placeholder: string(p)})
+		return nil
+	}
+
+	if rv.Kind() != reflect.Interface && rv.CanAddr() && rv.Addr().Type().Implements(unmarshalerType) {
+		return rv.Addr().Interface().(Unmarshaler).UnmarshalJet(d.toInterface(node))
+	}
+
+	if node == nil {
+		// The null token clears values that can be nil and leaves the
+		// others untouched.
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return d.decode(node, rv.Elem())
+	}
+
+	if s, ok := scalarText(node); ok {
+		if handled, err := d.decodeText(s, rv); handled {
+			return err
+		}
+	}
+
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		if value := d.toInterface(node); value != nil {
+			rv.Set(reflect.ValueOf(value))
+		} else {
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		return d.decodeObject(n, rv)
+	case []interface{}:
+		return d.decodeList(n, rv)
+	case string:
+		return d.decodeScalar(n, rv)
+	case quotedString:
+		return d.decodeScalar(string(n), rv)
+	}
+	return nil
+}
+
+// scalarText returns the text of a scalar node.
+func scalarText(node interface{}) (string, bool) {
+	switch n := node.(type) {
+	case string:
+		return n, true
+	case quotedString:
+		return string(n), true
+	}
+	return "", false
+}
+
+// decodeText restores the types Marshal writes through their text form:
+// time.Duration, time.Time in the layout of a format tag option, and
+// implementations of encoding.TextUnmarshaler, which include time.Time.
+// It reports false for any other type.
+func (d *decodeState) decodeText(s string, rv reflect.Value) (bool, error) {
+	if rv.Type() == durationType {
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return true, d.typeError("scalar "+strconv.Quote(s), rv.Type())
+		}
+		rv.SetInt(int64(dur))
+		return true, nil
+	}
+	if format := d.opts.Get("format"); rv.Type() == timeType && format != "" {
+		t, err := parseTime(s, format)
+		if err != nil {
+			return true, d.typeError("scalar "+strconv.Quote(s), rv.Type())
+		}
+		rv.Set(reflect.ValueOf(t))
+		return true, nil
+	}
+	if rv.Kind() == reflect.Interface || !rv.CanAddr() || !rv.Addr().Type().Implements(textUnmarshalerType) {
+		return false, nil
+	}
+	return true, rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+}
+
+func (d *decodeState) decodeObject(obj map[string]interface{}, rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Struct:
+		fields := cachedTypeFields(rv.Type())
+		for key, value := range obj {
+			f, ok := lookupField(fields, key)
+			if !ok {
+				// Unknown keys go to an inline map, or are ignored
+				if m, ok := inlineMap(rv, fields); ok {
+					if err := d.decodeMapEntry(key, value, m); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			fv, ok := fieldByIndexAlloc(rv, f.index)
+			if !ok {
+				continue
+			}
+			if err := d.decodeField(key, f.opts, value, fv); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if !isMapKeyType(rv.Type().Key()) {
+			return d.typeError("object", rv.Type())
+		}
+		for key, value := range obj {
+			if err := d.decodeMapEntry(key, value, rv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return d.typeError("object", rv.Type())
+}
+
+// decodeMapEntry decodes value into a new element of the map rv, converting
+// key to the key type of the map and allocating the map if needed.
+func (d *decodeState) decodeMapEntry(key string, value interface{}, rv reflect.Value) error {
+	t := rv.Type()
+	kv, err := d.mapKey(key, t.Key())
+	if err != nil {
+		return err
+	}
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(t))
+	}
+	elem := reflect.New(t.Elem()).Elem()
+	if err := d.decodeChild(key, value, elem); err != nil {
+		return err
+	}
+	rv.SetMapIndex(kv, elem)
+	return nil
+}
+
+// isMapKeyType reports whether Unmarshal can convert keys to type t.
+func isMapKeyType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// mapKey converts a Jet key back to a map key of type t, the inverse of
+// mapKeyString.
+func (d *decodeState) mapKey(key string, t reflect.Type) (reflect.Value, error) {
+	kv := reflect.New(t).Elem()
+	if t.Kind() == reflect.String {
+		kv.SetString(key)
+		return kv, nil
+	}
+	if u, ok := kv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, err
+		}
+		return kv, nil
+	}
+
+	var err error
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(key, 10, t.Bits()); err == nil {
+			kv.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		if n, err = strconv.ParseUint(key, 10, t.Bits()); err == nil {
+			kv.SetUint(n)
+		}
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(key); err == nil {
+			kv.SetBool(b)
+		}
+	}
+	if err != nil {
+		return reflect.Value{}, d.typeError("key "+strconv.Quote(key), t)
+	}
+	return kv, nil
+}
+
+// inlineMap returns the map field of the struct rv tagged inline, which
+// collects the keys matching no other field.
+func inlineMap(rv reflect.Value, fields []field) (reflect.Value, bool) {
+	for _, f := range fields {
+		if !f.opts.Contains("inline") || f.typ.Kind() != reflect.Map || f.typ.Key().Kind() != reflect.String {
+			continue
+		}
+		if m, ok := fieldByIndexAlloc(rv, f.index); ok {
+			return m, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func (d *decodeState) decodeList(list []interface{}, rv reflect.Value) error {
+	if rv.Kind() == reflect.Array {
+		// Extra items are dropped and missing ones leave zero values.
+		for i := 0; i < rv.Len(); i++ {
+			if i >= len(list) {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+				continue
+			}
+			if err := d.decodeChild(strconv.Itoa(i), list[i], rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if rv.Kind() != reflect.Slice {
+		return d.typeError("array", rv.Type())
+	}
+
+	slice := reflect.MakeSlice(rv.Type(), len(list), len(list))
+	for i, item := range list {
+		if err := d.decodeChild(strconv.Itoa(i), item, slice.Index(i)); err != nil {
+			return err
+		}
+	}
+	rv.Set(slice)
+	return nil
+}
+
+func (d *decodeState) decodeScalar(s string, rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return d.decodeBytes(s, rv)
+		}
+	case reflect.String:
+		rv.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			break
+		}
+		rv.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			break
+		}
+		rv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			break
+		}
+		rv.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, rv.Type().Bits())
+		if err != nil {
+			break
+		}
+		rv.SetFloat(f)
+		return nil
+	}
+	return d.typeError("scalar "+strconv.Quote(s), rv.Type())
+}
+
+// decodeBytes decodes the base64 form Marshal writes for byte slices and
+// arrays, or the hex form selected by the format=hex or hex tag options. An array only
+// accepts data of its exact length.
+func (d *decodeState) decodeBytes(s string, rv reflect.Value) error {
+	var (
+		b   []byte
+		err error
+	)
+	if d.opts.Contains("hex") || d.opts.Get("format") == "hex" {
+		b, err = hex.DecodeString(s)
+	} else {
+		b, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil || (rv.Kind() == reflect.Array && len(b) != rv.Len()) {
+		return d.typeError("scalar "+strconv.Quote(s), rv.Type())
+	}
+
+	if rv.Kind() == reflect.Slice {
+		rv.Set(reflect.MakeSlice(rv.Type(), len(b), len(b)))
+	}
+	for i, c := range b {
+		rv.Index(i).SetUint(uint64(c))
+	}
+	return nil
+}
+
+// toInterface converts a node into the value stored in an interface{}:
+// objects become map[string]interface{}, arrays []interface{} and scalars
+// bool, int, float64 or string depending on their text. Integers too large
+// for an int but within uint64 become a uint64.
+func (d *decodeState) toInterface(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			d.path = append(d.path, k)
+			result[k] = d.toInterface(v)
+			d.path = d.path[:len(d.path)-1]
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(n))
+		for i, v := range n {
+			d.path = append(d.path, strconv.Itoa(i))
+			result[i] = d.toInterface(v)
+			d.path = d.path[:len(d.path)-1]
+		}
+		return result
+	case string:
+		return inferScalar(n)
+	case quotedString:
+		return string(n)
+	case placeholder:
+		d.saveError(&LossyFieldError{Field: d.field(), Placeholder: string(n)})
+	}
+	return nil
+}
+
+func inferScalar(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if !isNumber(s) {
+		return s
+	}
+	if n, err := strconv.ParseInt(s, 10, 0); err == nil {
+		return int(n)
+	}
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// isNumber reports whether s is a decimal number such as -12, 3.5 or 1e+21.
+// It rejects the extra forms accepted by strconv like "Inf" or "0x1p-2".
+func isNumber(s string) bool {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	start := i
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == start {
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		start = i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		start = i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	return i == len(s)
+}
+
+// lookupField returns the struct field named key, preferring an exact match
+// over a case-insensitive one.
+func lookupField(fields []field, key string) (field, bool) {
+	fold := -1
+	for i, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+		if fold < 0 && strings.EqualFold(f.name, key) {
+			fold = i
+		}
+	}
+	if fold < 0 {
+		return field{}, false
+	}
+	return fields[fold], true
+}
@@ -0,0 +1,1103 @@
+package jet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalSimpleStruct(t *testing.T) {
+	type Person struct {
+		Name string
+		Age  int
+		City string
+	}
+
+	var person Person
+	err := Unmarshal([]byte("age: 30\ncity: Wonderland\nname: Alice\n"), &person)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	expected := Person{Name: "Alice", Age: 30, City: "Wonderland"}
+	if person != expected {
+		t.Errorf("Expected %+v, got %+v", expected, person)
+	}
+}
+
+func TestUnmarshalTabularArray(t *testing.T) {
+	type Product struct {
+		ID       int
+		Name     string
+		Category string
+	}
+
+	input := "products{category|id|name}:\n  Electronics|1|Laptop\n  Literature|3|Book\n"
+
+	var result struct {
+		Products []Product
+	}
+	if err := Unmarshal([]byte(input), &result); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	expected := []Product{
+		{ID: 1, Name: "Laptop", Category: "Electronics"},
+		{ID: 3, Name: "Book", Category: "Literature"},
+	}
+	if !reflect.DeepEqual(result.Products, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result.Products)
+	}
+}
+
+func TestUnmarshalNestedBlocks(t *testing.T) {
+	type Profile struct {
+		Username string
+		Email    string
+	}
+
+	type Person struct {
+		Name    string
+		Age     int
+		Profile Profile
+	}
+
+	input := "{age|name|profile}:\n 30|Alice\n   > profile:\n  email: alice@example.com\n  username: alice\n 25|Bob\n   > profile:\n  email: bob@example.com\n  username: bob\n"
+
+	var persons []Person
+	if err := Unmarshal([]byte(input), &persons); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	expected := []Person{
+		{Name: "Alice", Age: 30, Profile: Profile{Username: "alice", Email: "alice@example.com"}},
+		{Name: "Bob", Age: 25, Profile: Profile{Username: "bob", Email: "bob@example.com"}},
+	}
+	if !reflect.DeepEqual(persons, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, persons)
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	type Address struct {
+		Street  string
+		City    string
+		Country string
+	}
+
+	type Item struct {
+		ProductID int
+		Quantity  int
+		Price     float64
+	}
+
+	type Order struct {
+		OrderID int64
+		Status  string `jet:"state"`
+		Items   []Item
+	}
+
+	type Customer struct {
+		ID       int
+		Name     string
+		Score    float32
+		Active   bool
+		Address  Address
+		Orders   []Order
+		Tags     []string
+		Internal string `jet:"-"`
+	}
+
+	type Response struct {
+		Customers []Customer
+		Config    map[string]string
+		Total     int32
+	}
+
+	original := Response{
+		Customers: []Customer{
+			{
+				ID:      1,
+				Name:    "Alice Smith",
+				Score:   9.5,
+				Active:  true,
+				Address: Address{Street: "123 Main St", City: "Wonderland", Country: "Fantasy"},
+				Orders: []Order{
+					{OrderID: 1000, Status: "completed", Items: []Item{{ProductID: 100, Quantity: 1, Price: 99.99}, {ProductID: 200, Quantity: 2, Price: 5}}},
+					{OrderID: 1001, Status: "pending", Items: []Item{}},
+				},
+				Tags: []string{"vip", "early"},
+			},
+			{
+				ID:      2,
+				Name:    "Bob",
+				Address: Address{Street: "1 Side Rd", City: "Builderland", Country: "Fantasy"},
+				Orders:  []Order{},
+				Tags:    []string{},
+			},
+		},
+		Config: map[string]string{"route": "/home", "method": "GET"},
+		Total:  2,
+	}
+
+	encoders := map[string]func(interface{}) ([]byte, error){
+		"Marshal":           Marshal,
+		"MarshalNormalized": MarshalNormalized,
+	}
+	for name, marshal := range encoders {
+		data, err := marshal(original)
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		t.Logf("%s output:\n%s", name, data)
+
+		var decoded Response
+		if err := Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal of %s output failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, original) {
+			t.Errorf("%s round trip mismatch:\nexpected %+v\ngot      %+v", name, original, decoded)
+		}
+	}
+}
+
+func TestUnmarshalNormalized(t *testing.T) {
+	type Geo struct {
+		Lat float64
+		Lng float64
+	}
+
+	type Profile struct {
+		Username string
+		Email    string
+	}
+
+	type Location struct {
+		City string
+		Geo  Geo
+	}
+
+	type Person struct {
+		Name     string
+		Age      int
+		Profile  Profile
+		Location Location
+	}
+
+	input := "persons{age|location|name|profile{email|username}}:\n" +
+		" 30|Alice\n" +
+		"   > location:\n" +
+		"  city: Wonderland\n" +
+		"  geo:\n" +
+		"   lat: 1.5\n" +
+		"   lng: -2\n" +
+		"   > profile:\n" +
+		"   alice@example.com|alice\n" +
+		" 25|Bob\n" +
+		"   > location:\n" +
+		"  city: Builderland\n" +
+		"  geo:\n" +
+		"   lat: 0\n" +
+		"   lng: 0\n" +
+		"   > profile:\n" +
+		"   bob@example.com|bob\n"
+
+	var result struct {
+		Persons []Person
+	}
+	if err := UnmarshalNormalized([]byte(input), &result); err != nil {
+		t.Fatalf("UnmarshalNormalized failed: %v", err)
+	}
+
+	expected := []Person{
+		{Name: "Alice", Age: 30, Profile: Profile{Username: "alice", Email: "alice@example.com"}, Location: Location{City: "Wonderland", Geo: Geo{Lat: 1.5, Lng: -2}}},
+		{Name: "Bob", Age: 25, Profile: Profile{Username: "bob", Email: "bob@example.com"}, Location: Location{City: "Builderland"}},
+	}
+	if !reflect.DeepEqual(result.Persons, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result.Persons)
+	}
+
+	var persons []Person
+	err := UnmarshalNormalized([]byte("{age|name|profile{email|username}}:\n 30|Alice\n   > profile:\n   alice@example.com\n"), &persons)
+	if err == nil {
+		t.Errorf("Expected error for nested row with missing cells")
+	}
+}
+
+func TestUnmarshalFlattened(t *testing.T) {
+	type Customer struct {
+		Name  string
+		Email string
+	}
+
+	type Order struct {
+		ID       int
+		Customer Customer
+		Total    float64
+	}
+
+	original := []Order{
+		{ID: 1, Customer: Customer{Name: "Alice", Email: "alice@example.com"}, Total: 10.5},
+		{ID: 2, Customer: Customer{Name: "Bob", Email: "bob@example.com"}, Total: 3},
+	}
+
+	data, err := MarshalFlattened(original)
+	if err != nil {
+		t.Fatalf("MarshalFlattened failed: %v", err)
+	}
+	t.Logf("Flattened output:\n%s", data)
+
+	var decoded []Order
+	if err := UnmarshalFlattened(data, &decoded); err != nil {
+		t.Fatalf("UnmarshalFlattened failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Expected %+v, got %+v", original, decoded)
+	}
+}
+
+func TestUnmarshalFlattenedLossy(t *testing.T) {
+	type Item struct {
+		SKU string
+		Qty int
+	}
+
+	type Order struct {
+		ID    int
+		Items []Item
+	}
+
+	original := []Order{
+		{ID: 1, Items: []Item{{SKU: "a", Qty: 1}}},
+		{ID: 2, Items: []Item{{SKU: "b", Qty: 2}}},
+	}
+
+	data, err := MarshalFlattened(original)
+	if err != nil {
+		t.Fatalf("MarshalFlattened failed: %v", err)
+	}
+
+	var decoded []Order
+	err = UnmarshalFlattened(data, &decoded)
+	lossy, ok := err.(*LossyFieldError)
+	if !ok {
+		t.Fatalf("Expected *LossyFieldError, got %v", err)
+	}
+	if lossy.Field != "0.items" || lossy.Placeholder != "[table]" {
+		t.Errorf("Expected field '0.items' lost as [table], got %q as %s", lossy.Field, lossy.Placeholder)
+	}
+
+	// The remaining fields are still decoded
+	if len(decoded) != 2 || decoded[0].ID != 1 || decoded[1].ID != 2 {
+		t.Errorf("Expected IDs to be decoded, got %+v", decoded)
+	}
+}
+
+func TestUnmarshalRoundTripScalars(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"string", "hello world"},
+		{"int", 42},
+		{"negative int", -7},
+		{"int32", int32(5)},
+		{"int64", int64(1) << 40},
+		{"int8", int8(-128)},
+		{"int16", int16(-300)},
+		{"uint", uint(7)},
+		{"uint8", uint8(255)},
+		{"uint16", uint16(65535)},
+		{"uint32", uint32(1) << 31},
+		{"uint64", uint64(1)<<63 + 1},
+		{"uintptr", uintptr(4096)},
+		{"float64", 3.14},
+		{"float32", float32(2.5)},
+		{"bool", true},
+		{"string slice", []string{"a", "b", "c"}},
+		{"int slice", []int{1, 2, 3}},
+		{"uint16 slice", []uint16{80, 443}},
+		{"map", map[string]int{"one": 1, "two": 2}},
+		{"int keys", map[int]string{10: "ten", 2: "two", -1: "minus one"}},
+		{"uint16 keys", map[uint16][]string{80: {"http"}, 443: {"https"}}},
+		{"bool keys", map[bool]int{true: 1, false: 0}},
+		{"text marshaler keys", map[testLevel]string{0: "quiet", 1: "loud"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(tt.value))
+			if err := Unmarshal(data, decoded.Interface()); err != nil {
+				t.Fatalf("Unmarshal of %q failed: %v", data, err)
+			}
+			if !reflect.DeepEqual(decoded.Elem().Interface(), tt.value) {
+				t.Errorf("Expected %#v, got %#v", tt.value, decoded.Elem().Interface())
+			}
+		})
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	original := map[string]interface{}{
+		"route":  "/api/data",
+		"count":  3,
+		"ratio":  0.5,
+		"active": true,
+		"id":     uint64(1)<<63 + 1,
+		"headers": []interface{}{
+			map[string]interface{}{"key": "Content-Type", "value": "application/json"},
+			map[string]interface{}{"key": "Authorization", "value": "Bearer token"},
+		},
+	}
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded interface{}
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Expected %#v, got %#v", original, decoded)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type Product struct {
+		ID   int
+		Name string
+	}
+
+	var product Product
+	if err := Unmarshal([]byte("id: 1\n"), product); err == nil {
+		t.Errorf("Expected error for non-pointer argument")
+	} else if _, ok := err.(*InvalidUnmarshalError); !ok {
+		t.Errorf("Expected *InvalidUnmarshalError, got %T", err)
+	}
+
+	err := Unmarshal([]byte("id: abc\nname: Laptop\n"), &product)
+	ute, ok := err.(*UnmarshalTypeError)
+	if !ok {
+		t.Fatalf("Expected *UnmarshalTypeError, got %v", err)
+	}
+	if ute.Field != "id" {
+		t.Errorf("Expected field 'id', got %q", ute.Field)
+	}
+
+	var products []Product
+	if err := Unmarshal([]byte("{id|name}:\n 1|Laptop|extra\n"), &products); err == nil {
+		t.Errorf("Expected error for row with too many cells")
+	}
+}
+
+func TestUnmarshalEscapedValues(t *testing.T) {
+	type Note struct {
+		Title string
+		Body  string
+	}
+
+	type Document struct {
+		Name  string
+		Notes []Note
+		Meta  map[string]string
+	}
+
+	tricky := []string{
+		"a|b",
+		"line one\nline two",
+		"key: value",
+		"ends with colon:",
+		"> looks like a nested block",
+		"- looks like a list item",
+		"\"quoted\"",
+		"back\\slash",
+		"  padded  ",
+		"tab\there",
+		"",
+		"42",
+		"true",
+		"[nested]",
+		"5\" screen|10\" screen",
+		"ünïcödé ✓",
+	}
+
+	original := Document{Name: "a|b: c", Meta: map[string]string{}}
+	for i, s := range tricky {
+		original.Notes = append(original.Notes, Note{Title: tricky[len(tricky)-1-i], Body: s})
+		original.Meta[s+"{x}|y,z"] = s
+	}
+
+	encoders := map[string]func(interface{}) ([]byte, error){
+		"Marshal":           Marshal,
+		"MarshalNormalized": MarshalNormalized,
+		"MarshalFlattened":  MarshalFlattened,
+	}
+	for name, marshal := range encoders {
+		data, err := marshal(original)
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		t.Logf("%s output:\n%s", name, data)
+
+		var decoded Document
+		if err := Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal of %s output failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, original) {
+			t.Errorf("%s round trip mismatch:\nexpected %q\ngot      %q", name, original, decoded)
+		}
+	}
+}
+
+func TestUnmarshalQuotedStringsStayStrings(t *testing.T) {
+	original := map[string]interface{}{
+		"zip":    "12345",
+		"flag":   "true",
+		"count":  12345,
+		"active": true,
+	}
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Expected %#v, got %#v", original, decoded)
+	}
+}
+
+func TestUnmarshalNull(t *testing.T) {
+	type Address struct {
+		Street string
+		City   string
+	}
+
+	type Person struct {
+		Name    string
+		Age     *int
+		Address *Address
+		Extra   interface{}
+		Labels  map[string]string
+		Tags    []string
+	}
+
+	age := 30
+	original := []Person{
+		{Name: "Alice", Age: &age, Address: &Address{Street: "1 Main St", City: "Wonderland"}, Extra: "note", Labels: map[string]string{"team": "a"}, Tags: []string{"vip"}},
+		{Name: "Bob"},
+		{Name: "~", Address: &Address{Street: "2 Side Rd", City: "Builderland"}},
+	}
+
+	marshalers := map[string]func(interface{}) ([]byte, error){
+		"Marshal":           Marshal,
+		"MarshalFlattened":  MarshalFlattened,
+		"MarshalNormalized": MarshalNormalized,
+	}
+	for name, marshal := range marshalers {
+		t.Run(name, func(t *testing.T) {
+			data, err := marshal(original)
+			if err != nil {
+				t.Fatalf("%s failed: %v", name, err)
+			}
+			t.Logf("Encoded:\n%s", data)
+
+			decoded := []Person{{Extra: "stale", Tags: []string{"stale"}}, {Extra: "stale", Labels: map[string]string{"stale": "x"}}}
+			if err := Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", original, decoded)
+			}
+		})
+	}
+
+	t.Run("interface", func(t *testing.T) {
+		var result interface{}
+		if err := Unmarshal([]byte("a: ~\nb: \"~\"\n"), &result); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		expected := map[string]interface{}{"a": nil, "b": "~"}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("top level", func(t *testing.T) {
+		p := &Person{Name: "Alice"}
+		if err := Unmarshal([]byte("~\n"), &p); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if p != nil {
+			t.Errorf("Expected nil pointer, got %+v", p)
+		}
+	})
+}
+
+func TestUnmarshalArraysAndBytes(t *testing.T) {
+	type Record struct {
+		ID       [16]byte
+		Checksum []byte   `jet:"sum,hex"`
+		Digest   *[4]byte `jet:",hex"`
+		Payload  []byte
+		Empty    []byte
+		Vector   [3]float64
+	}
+
+	digest := [4]byte{0xca, 0xfe, 0xba, 0xbe}
+	original := []Record{
+		{
+			ID:       [16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8},
+			Checksum: []byte{0x01, 0xab},
+			Digest:   &digest,
+			Payload:  []byte("hello|world"),
+			Empty:    []byte{},
+			Vector:   [3]float64{1, 2.5, -3},
+		},
+		{Payload: []byte{0, 255}, Empty: []byte{}},
+	}
+
+	for name, marshal := range map[string]func(interface{}) ([]byte, error){
+		"Marshal":           Marshal,
+		"MarshalFlattened":  MarshalFlattened,
+		"MarshalNormalized": MarshalNormalized,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := marshal(original)
+			if err != nil {
+				t.Fatalf("%s failed: %v", name, err)
+			}
+			t.Logf("Encoded:\n%s", data)
+
+			var decoded []Record
+			if err := Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", original, decoded)
+			}
+		})
+	}
+
+	var short struct{ ID [16]byte }
+	if err := Unmarshal([]byte("id: AQID\n"), &short); err == nil {
+		t.Errorf("Expected error for byte array of the wrong length")
+	}
+}
+
+func TestUnmarshalTextTypes(t *testing.T) {
+	type Event struct {
+		Name     string
+		At       time.Time
+		Deadline *time.Time
+		Timeout  time.Duration
+		Level    testLevel
+	}
+
+	deadline := time.Date(2024, 3, 2, 0, 0, 0, 0, time.FixedZone("", 2*60*60))
+	original := []Event{
+		{Name: "deploy", At: time.Date(2024, 3, 1, 9, 30, 0, 500, time.UTC), Deadline: &deadline, Timeout: 90 * time.Second, Level: 1},
+		{Name: "backup", At: time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC), Timeout: 2 * time.Hour},
+	}
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	t.Logf("Encoded:\n%s", data)
+
+	var decoded []Event
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded) != len(original) {
+		t.Fatalf("Expected %d events, got %d", len(original), len(decoded))
+	}
+	for i := range original {
+		want, got := original[i], decoded[i]
+		if !got.At.Equal(want.At) || got.Timeout != want.Timeout || got.Level != want.Level {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want, got)
+		}
+		if (want.Deadline == nil) != (got.Deadline == nil) || (want.Deadline != nil && !got.Deadline.Equal(*want.Deadline)) {
+			t.Errorf("Event %d: expected deadline %v, got %v", i, want.Deadline, got.Deadline)
+		}
+	}
+
+	var event Event
+	if err := Unmarshal([]byte("level: medium\n"), &event); err == nil {
+		t.Errorf("Expected error from UnmarshalText")
+	}
+}
+
+func TestUnmarshalCustomUnmarshaler(t *testing.T) {
+	type LineItem struct {
+		SKU      string
+		Price    testMoney
+		Discount *testMoney
+	}
+
+	original := []LineItem{
+		{SKU: "A-1", Price: testMoney{Cents: 1250, Currency: "USD"}, Discount: &testMoney{Cents: 100, Currency: "USD"}},
+		{SKU: "B-2", Price: testMoney{Cents: 99, Currency: "EUR"}},
+	}
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	t.Logf("Encoded:\n%s", data)
+
+	var decoded []LineItem
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", original, decoded)
+	}
+
+	var item LineItem
+	if err := Unmarshal([]byte("price:\n  cents: 5\n"), &item); err == nil {
+		t.Errorf("Expected error from UnmarshalJet")
+	}
+}
+
+func TestUnmarshalEmbedded(t *testing.T) {
+	type Base struct {
+		ID      int
+		Created string
+	}
+
+	type Owner struct {
+		Owner string
+	}
+
+	type Product struct {
+		Base
+		*Owner
+		testAudit
+		Name  string
+		stock int
+	}
+
+	input := "{author|created|id|name|owner|stock}:\n bob|2024-01-01|1|Laptop|ann|5\n eve|2024-02-01|2|Mouse|~|9\n"
+
+	var products []Product
+	if err := Unmarshal([]byte(input), &products); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	expected := []Product{
+		{Base: Base{ID: 1, Created: "2024-01-01"}, Owner: &Owner{Owner: "ann"}, testAudit: testAudit{Author: "bob"}, Name: "Laptop"},
+		{Base: Base{ID: 2, Created: "2024-02-01"}, Owner: &Owner{}, testAudit: testAudit{Author: "eve"}, Name: "Mouse"},
+	}
+	if !reflect.DeepEqual(products, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, products)
+	}
+}
+
+func TestUnmarshalEmbeddedConflicts(t *testing.T) {
+	type BaseEntity struct {
+		ID      int
+		Created string
+	}
+
+	type Audit struct {
+		Created string `jet:"created"`
+		By      string
+	}
+
+	type Meta struct {
+		Version string
+	}
+
+	type Order struct {
+		BaseEntity
+		Audit
+		Meta   Meta       `jet:",inline"`
+		Extra  BaseEntity `jet:"extra"`
+		Status string
+	}
+
+	original := []Order{
+		{BaseEntity: BaseEntity{ID: 1}, Audit: Audit{Created: "2024-01-01", By: "ann"}, Meta: Meta{Version: "v1"}, Extra: BaseEntity{ID: 9, Created: "x"}, Status: "open"},
+		{BaseEntity: BaseEntity{ID: 2}, Audit: Audit{Created: "2024-02-01", By: "bob"}, Meta: Meta{Version: "v2"}, Extra: BaseEntity{ID: 8, Created: "y"}, Status: "closed"},
+	}
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	t.Logf("Encoded:\n%s", data)
+
+	var decoded []Order
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", original, decoded)
+	}
+}
+
+func TestUnmarshalTagOptions(t *testing.T) {
+	type Dims struct {
+		W int `jet:"w,omitempty"`
+		H int
+	}
+
+	type Product struct {
+		ID      int               `jet:"id,string"`
+		Name    string            `jet:"name"`
+		Note    string            `jet:"note,omitempty"`
+		Stock   int               `jet:"stock,omitempty"`
+		Hash    []byte            `jet:"hash,format=hex"`
+		Dims    Dims              `jet:"dims"`
+		Added   time.Time         `jet:"added,format=date"`
+		Updated time.Time         `jet:"updated,format=unixmilli"`
+		Extras  map[string]string `jet:",inline"`
+	}
+
+	added := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 5, 7, 8, 9, 10, 11e6, time.UTC)
+	original := []Product{
+		{ID: 1, Name: "Laptop", Stock: 5, Hash: []byte{0xab}, Dims: Dims{W: 3, H: 2}, Added: added, Updated: updated},
+		{ID: 2, Name: "Mouse", Note: "wireless", Hash: []byte{0xcd}, Dims: Dims{H: 1}, Added: added, Updated: updated},
+	}
+
+	for name, marshal := range map[string]func(interface{}) ([]byte, error){
+		"Marshal":           Marshal,
+		"MarshalFlattened":  MarshalFlattened,
+		"MarshalNormalized": MarshalNormalized,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := marshal(original)
+			if err != nil {
+				t.Fatalf("%s failed: %v", name, err)
+			}
+			t.Logf("Encoded:\n%s", data)
+
+			var decoded []Product
+			if err := Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", original, decoded)
+			}
+		})
+	}
+
+	var product Product
+	if err := Unmarshal([]byte("name: Cable\ncolor: red\nsize: 2m\n"), &product); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	expected := map[string]string{"color": "red", "size": "2m"}
+	if !reflect.DeepEqual(product.Extras, expected) {
+		t.Errorf("Expected inline map %v, got %v", expected, product.Extras)
+	}
+}
+
+func TestUnmarshalMapKeyErrors(t *testing.T) {
+	var counts map[uint8]int
+	err := Unmarshal([]byte("300: 1\n"), &counts)
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("Expected *UnmarshalTypeError for out of range key, got %v", err)
+	}
+
+	var levels map[testLevel]int
+	if err := Unmarshal([]byte("medium: 1\n"), &levels); err == nil {
+		t.Errorf("Expected error from UnmarshalText for key")
+	}
+
+	var floats map[float64]int
+	if err := Unmarshal([]byte("1.5: 1\n"), &floats); err == nil {
+		t.Errorf("Expected error for float map keys")
+	}
+}
+
+func TestUnmarshalInlineLists(t *testing.T) {
+	type post struct {
+		ID    int      `jet:"id"`
+		Tags  []string `jet:"tags"`
+		Sizes [3]int   `jet:"sizes"`
+	}
+	posts := []post{
+		{1, []string{"a,b", "x]", "two words", "p|q", "42", ""}, [3]int{1, 2, 3}},
+		{2, []string{}, [3]int{}},
+		{3, nil, [3]int{7}},
+	}
+
+	for name, marshal := range map[string]func(interface{}) ([]byte, error){
+		"normal": Marshal, "normalized": MarshalNormalized, "flattened": MarshalFlattened,
+	} {
+		data, err := marshal(posts)
+		if err != nil {
+			t.Fatalf("%s: Marshal failed: %v", name, err)
+		}
+		var got []post
+		if err := Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: Unmarshal failed: %v\n%s", name, err, data)
+		}
+		if !reflect.DeepEqual(got, posts) {
+			t.Errorf("%s: expected %+v, got %+v\n%s", name, posts, got, data)
+		}
+	}
+
+	var v interface{}
+	if err := Unmarshal([]byte("tags: [go, \"a,b\", 3, ~]\n"), &v); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	expected := map[string]interface{}{"tags": []interface{}{"go", "a,b", 3, nil}}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, v)
+	}
+}
+
+func TestUnmarshalSparseTables(t *testing.T) {
+	type profile struct {
+		Email string `jet:"email"`
+	}
+	type user struct {
+		ID      int      `jet:"id"`
+		Name    string   `jet:"name"`
+		Nick    *string  `jet:"nick"`
+		Profile *profile `jet:"profile"`
+	}
+	nick := "al"
+	users := []map[string]interface{}{
+		{"id": 1, "name": "Alice", "nick": nick, "profile": map[string]string{"email": "a@x"}},
+		{"id": 2, "name": "Bob"},
+		{"id": 3, "name": "Carol", "profile": map[string]string{"email": "c@x"}},
+	}
+	expected := []user{
+		{1, "Alice", &nick, &profile{"a@x"}},
+		{2, "Bob", nil, nil},
+		{3, "Carol", nil, &profile{"c@x"}},
+	}
+
+	for _, format := range []Format{FormatNormal, FormatNormalized, FormatFlattened} {
+		data, err := MarshalWithOptions(users, EncodeOptions{Format: format, MinKeyOverlap: 0.5})
+		if err != nil {
+			t.Fatalf("format %d: Marshal failed: %v", format, err)
+		}
+		var got []user
+		if err := Unmarshal(data, &got); err != nil {
+			t.Fatalf("format %d: Unmarshal failed: %v\n%s", format, err, data)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("format %d: expected %+v, got %+v\n%s", format, expected, got, data)
+		}
+
+		var generic []map[string]interface{}
+		if err := Unmarshal(data, &generic); err != nil {
+			t.Fatalf("format %d: Unmarshal failed: %v", format, err)
+		}
+		if _, ok := generic[1]["nick"]; ok {
+			t.Errorf("format %d: expected missing key to stay absent, got %v", format, generic[1])
+		}
+	}
+}
+
+func TestUnmarshalListItems(t *testing.T) {
+	values := []interface{}{
+		[]interface{}{
+			map[string]interface{}{"id": 1, "name": "Alice", "tags": []interface{}{"a", "b"}},
+			"plain",
+			[]interface{}{1, map[string]interface{}{"deep": []interface{}{map[string]interface{}{"x": 1}, 2}}},
+			[]interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}},
+			map[string]interface{}{},
+		},
+		map[string]interface{}{
+			"mixed": []interface{}{map[string]interface{}{"k": "v"}, nil, true},
+		},
+	}
+
+	for _, value := range values {
+		for name, marshal := range map[string]func(interface{}) ([]byte, error){
+			"normal": Marshal, "normalized": MarshalNormalized, "flattened": MarshalFlattened,
+		} {
+			data, err := marshal(value)
+			if err != nil {
+				t.Fatalf("%s: Marshal failed: %v", name, err)
+			}
+			var got interface{}
+			if err := Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s: Unmarshal failed: %v\n%s", name, err, data)
+			}
+			if !reflect.DeepEqual(got, value) {
+				t.Errorf("%s: expected %#v, got %#v\n%s", name, value, got, data)
+			}
+		}
+	}
+
+	type step struct {
+		Name string   `jet:"name"`
+		Args []string `jet:"args"`
+	}
+	type job struct {
+		ID    int           `jet:"id"`
+		Steps []interface{} `jet:"steps"`
+	}
+	jobs := []job{{1, []interface{}{map[string]interface{}{"name": "build"}, "sleep"}}}
+	data, err := Marshal(jobs)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got []job
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, jobs) {
+		t.Errorf("Expected %#v, got %#v\n%s", jobs, got, data)
+	}
+
+	var steps []step
+	if err := Unmarshal([]byte("- name: build\n  args: [-o,bin]\n-\n  name: test\n"), &steps); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	expected := []step{{"build", []string{"-o", "bin"}}, {"test", nil}}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, steps)
+	}
+}
+
+func TestUnmarshalMatrices(t *testing.T) {
+	type hit struct {
+		Query  string      `jet:"query"`
+		Scores [][]float64 `jet:"scores"`
+	}
+	type result struct {
+		Embeddings [][]float64 `jet:"embeddings"`
+		Grid       [2][3]int   `jet:"grid"`
+		Ragged     [][]string  `jet:"ragged"`
+		Hits       []hit       `jet:"hits"`
+	}
+	value := result{
+		Embeddings: [][]float64{{0.25, -1.5}, {3, 4e-9}},
+		Grid:       [2][3]int{{1, 2, 3}, {4, 5, 6}},
+		Ragged:     [][]string{{"a,b", "c|d"}, {}, {"[x]", "", "two words"}},
+		Hits: []hit{
+			{"cats", [][]float64{{0.9, 0.1}, {0.2, 0.8}}},
+			{"dogs", [][]float64{{0.5}}},
+		},
+	}
+
+	for _, format := range []Format{FormatNormal, FormatNormalized} {
+		data, err := MarshalWithOptions(value, EncodeOptions{Format: format})
+		if err != nil {
+			t.Fatalf("format %d: Marshal failed: %v", format, err)
+		}
+		var got result
+		if err := Unmarshal(data, &got); err != nil {
+			t.Fatalf("format %d: Unmarshal failed: %v\n%s", format, err, data)
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("format %d: expected %+v, got %+v\n%s", format, value, got, data)
+		}
+	}
+
+	var v interface{}
+	if err := Unmarshal([]byte("m[2 x *]:\n 1|a\n ~\n"), &v); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	expected := map[string]interface{}{"m": []interface{}{[]interface{}{1, "a"}, []interface{}{nil}}}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, v)
+	}
+
+	for _, input := range []string{"m[2x2]:\n 1|2\n", "m[1x2]:\n 1|2|3\n"} {
+		var m map[string][][]int
+		if _, ok := Unmarshal([]byte(input), &m).(*SyntaxError); !ok {
+			t.Errorf("Expected *SyntaxError for %q", input)
+		}
+	}
+}
+
+func TestUnmarshalElided(t *testing.T) {
+	type user struct {
+		Name    string            `jet:"name"`
+		Address map[string]string `jet:"address"`
+	}
+	type account struct {
+		ID   int   `jet:"id"`
+		User *user `jet:"user"`
+	}
+	data, err := MarshalWithOptions([]account{{1, &user{"Alice", map[string]string{"city": "Paris"}}}}, EncodeOptions{MaxDepth: 3})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var got []account
+	err = Unmarshal(data, &got)
+	lossy, ok := err.(*LossyFieldError)
+	if !ok || lossy.Placeholder != "..." || lossy.Field != "0.user.address" {
+		t.Fatalf("Expected *LossyFieldError for 0.user.address, got %v\n%s", err, data)
+	}
+	if len(got) != 1 || got[0].User == nil || got[0].User.Name != "Alice" || got[0].User.Address != nil {
+		t.Errorf("Expected the rest of the document to be decoded, got %+v", got)
+	}
+
+	var s string
+	if err := Unmarshal([]byte(`"..."`), &s); err != nil || s != "..." {
+		t.Errorf("Expected quoted ellipsis to decode as a string, got %q, %v", s, err)
+	}
+}
+
+func TestUnmarshalEmptySchema(t *testing.T) {
+	type Sparse struct {
+		Note string `jet:"note,omitempty"`
+		Code int    `jet:"code,omitempty"`
+	}
+	type Hidden struct {
+		id   int
+		name string
+	}
+	type Doc struct {
+		Sparse []Sparse
+		Hidden []Hidden
+		Title  string
+	}
+
+	doc := Doc{Sparse: make([]Sparse, 2), Hidden: []Hidden{{id: 1}, {name: "x"}, {}}, Title: "t"}
+	for _, opts := range []EncodeOptions{{}, {Format: FormatNormalized}, {Format: FormatFlattened}} {
+		result, err := MarshalWithOptions(doc, opts)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !strings.Contains(string(result), "sparse{}:\n") || !strings.Contains(string(result), "hidden{}:\n") {
+			t.Errorf("Expected tables without columns, got:\n%s", result)
+		}
+
+		var decoded Doc
+		if err := Unmarshal(result, &decoded); err != nil {
+			t.Fatalf("Unmarshal failed: %v\n%s", err, result)
+		}
+		if len(decoded.Sparse) != 2 || len(decoded.Hidden) != 3 || decoded.Title != "t" {
+			t.Errorf("Expected 2 sparse and 3 hidden rows, got %+v", decoded)
+		}
+	}
+
+	// Through the generic encoder as well
+	rows := []map[string]interface{}{{}, {}}
+	result, err := Marshal(rows)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var decoded []map[string]interface{}
+	if err := Unmarshal(result, &decoded); err != nil {
+		t.Fatalf("Unmarshal of %q failed: %v", result, err)
+	}
+	if len(decoded) != 2 {
+		t.Errorf("Expected two rows from %q, got %v", result, decoded)
+	}
+}